@@ -0,0 +1,12 @@
+-- Follows table (social graph)
+CREATE TABLE IF NOT EXISTS follows (
+    follower_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    followee_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (follower_id, followee_id),
+    CHECK (follower_id <> followee_id)
+);
+
+-- Reverse lookup: who follows a given user
+CREATE INDEX IF NOT EXISTS idx_follows_followee
+  ON follows(followee_id);
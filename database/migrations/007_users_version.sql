@@ -0,0 +1,2 @@
+-- Row version for optimistic concurrency on user updates
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,2 @@
+-- Set when an account must change its password before logging in again
+ALTER TABLE users ADD COLUMN IF NOT EXISTS must_reset_password BOOLEAN NOT NULL DEFAULT FALSE;
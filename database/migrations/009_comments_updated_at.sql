@@ -0,0 +1,2 @@
+-- Set when a comment's content is edited
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
@@ -0,0 +1,8 @@
+-- Nested comments: a reply points at its parent comment on the same post
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS parent_id UUID REFERENCES comments(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_comments_parent_created_at
+  ON comments(parent_id, created_at DESC);
+
+-- Marker for the replies notification feed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen_replies_at TIMESTAMPTZ;
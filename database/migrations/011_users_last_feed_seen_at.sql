@@ -0,0 +1,2 @@
+-- Marker for the "catch up" feed of followed users' posts
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_feed_seen_at TIMESTAMPTZ;
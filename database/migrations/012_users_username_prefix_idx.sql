@@ -0,0 +1,3 @@
+-- Prefix lookups on username (LIKE 'abc%') regardless of collation
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_users_username_pattern
+  ON users(username text_pattern_ops);
@@ -0,0 +1,6 @@
+-- Content fingerprint for per-author duplicate detection (DEDUP_POSTS)
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS content_hash BYTEA
+  GENERATED ALWAYS AS (sha256(convert_to(content, 'UTF8'))) STORED;
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_posts_author_content_hash
+  ON posts(author_id, content_hash);
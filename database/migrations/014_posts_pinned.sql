@@ -0,0 +1,5 @@
+-- Profile pinning: pinned posts sort ahead of the author's other posts
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS pinned BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_posts_author_pinned_created_at
+  ON posts(author_id, pinned DESC, created_at DESC);
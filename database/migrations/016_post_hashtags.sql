@@ -0,0 +1,30 @@
+-- Hashtags extracted from post content when the post is written, so trending
+-- queries aggregate a narrow indexed table instead of regex-scanning posts
+CREATE TABLE IF NOT EXISTS post_hashtags (
+    post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    tag TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (post_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_hashtags_created_at ON post_hashtags(created_at, tag);
+
+CREATE OR REPLACE FUNCTION extract_post_hashtags() RETURNS trigger AS $$
+BEGIN
+  INSERT INTO post_hashtags (post_id, tag, created_at)
+  SELECT DISTINCT NEW.id, lower(m[1]), NEW.created_at
+  FROM regexp_matches(NEW.content, '#([[:alnum:]_]+)', 'g') AS m
+  ON CONFLICT DO NOTHING;
+  RETURN NEW;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS posts_extract_hashtags ON posts;
+CREATE TRIGGER posts_extract_hashtags AFTER INSERT ON posts
+  FOR EACH ROW EXECUTE FUNCTION extract_post_hashtags();
+
+-- Backfill existing posts
+INSERT INTO post_hashtags (post_id, tag, created_at)
+SELECT DISTINCT p.id, lower(m[1]), p.created_at
+FROM posts p
+CROSS JOIN LATERAL regexp_matches(p.content, '#([[:alnum:]_]+)', 'g') AS m
+ON CONFLICT DO NOTHING;
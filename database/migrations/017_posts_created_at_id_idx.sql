@@ -0,0 +1,3 @@
+-- Keyset pagination (SNAPSHOT_PAGINATION) orders by (created_at, id) for a total order
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_posts_created_at_id
+  ON posts(created_at DESC, id DESC);
@@ -0,0 +1,9 @@
+-- Drafts: only published posts are listed; published_at is set when a draft goes live
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'published'
+  CHECK (status IN ('draft', 'published'));
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS published_at TIMESTAMPTZ DEFAULT now();
+
+UPDATE posts SET published_at = created_at WHERE status = 'published';
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_posts_author_drafts
+  ON posts(author_id, created_at DESC) WHERE status = 'draft';
@@ -0,0 +1,2 @@
+-- Per-user notification settings; keys are validated by the API before writing
+ALTER TABLE users ADD COLUMN IF NOT EXISTS notification_prefs JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,7 @@
+-- Per-post seen markers for the /feed/new unread feed
+CREATE TABLE IF NOT EXISTS post_views (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    viewed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, post_id)
+);
@@ -0,0 +1,11 @@
+-- Records applied migrations so the harness can check the schema version
+-- (GET /admin/dataset-info). Migrations run in order, so reaching this one means
+-- 001-021 are all in place. Later migrations insert their own number.
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO schema_migrations (version)
+SELECT generate_series(1, 21)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,11 @@
+-- Previous password hashes, so PASSWORD_HISTORY_SIZE can reject reuse on change
+CREATE TABLE IF NOT EXISTS password_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_history_user ON password_history(user_id, id DESC);
+
+INSERT INTO schema_migrations (version) VALUES (22) ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+-- Same as me.sql plus the row version, used as the /auth/me ETag
+SELECT id, username, email, bio, created_at, version FROM users WHERE id = $1;
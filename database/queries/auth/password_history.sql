@@ -0,0 +1,6 @@
+-- Most recent first; $2 is the history size
+SELECT password_hash
+FROM password_history
+WHERE user_id = $1
+ORDER BY id DESC
+LIMIT $2;
@@ -0,0 +1,13 @@
+-- Keeps the newest $3 - 1 entries and adds $2, leaving at most $3 per user
+WITH trimmed AS (
+    DELETE FROM password_history
+    WHERE user_id = $1
+      AND id NOT IN (
+          SELECT id FROM password_history
+          WHERE user_id = $1
+          ORDER BY id DESC
+          LIMIT $3 - 1
+      )
+)
+INSERT INTO password_history (user_id, password_hash)
+VALUES ($1, $2);
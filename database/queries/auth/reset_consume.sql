@@ -0,0 +1,11 @@
+-- Spends a valid token along with every other outstanding token of its user
+WITH used AS (
+    DELETE FROM password_resets
+    WHERE token_hash = $1 AND expires_at > NOW()
+    RETURNING user_id
+), others AS (
+    DELETE FROM password_resets r
+    USING used
+    WHERE r.user_id = used.user_id
+)
+SELECT user_id FROM used;
@@ -0,0 +1,6 @@
+-- Inserts nothing when no user has the email
+INSERT INTO password_resets (token_hash, user_id, expires_at)
+SELECT $2, u.id, NOW() + make_interval(mins => $3)
+FROM users u
+WHERE u.email = $1
+RETURNING user_id;
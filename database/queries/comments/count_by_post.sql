@@ -0,0 +1,3 @@
+SELECT COUNT(*)::bigint
+FROM comments
+WHERE post_id = $1;
@@ -0,0 +1,6 @@
+-- Same as create.sql, inserting nothing unless the post exists and is published
+INSERT INTO comments (author_id, post_id, content)
+SELECT $1, p.id, $3
+FROM posts p
+WHERE p.id = $2 AND p.status = 'published'
+RETURNING id, author_id, post_id, content, created_at;
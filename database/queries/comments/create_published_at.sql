@@ -0,0 +1,6 @@
+-- Same as create_published.sql with created_at supplied by the app (MONOTONIC_CREATED_AT)
+INSERT INTO comments (author_id, post_id, content, created_at)
+SELECT $1, p.id, $3, $4
+FROM posts p
+WHERE p.id = $2 AND p.status = 'published'
+RETURNING id, author_id, post_id, content, created_at;
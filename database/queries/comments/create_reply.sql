@@ -0,0 +1,6 @@
+-- Inserts nothing when the parent is missing or belongs to another post
+INSERT INTO comments (author_id, post_id, content, parent_id)
+SELECT $1, $2, $3, parent.id
+FROM comments parent
+WHERE parent.id = $4 AND parent.post_id = $2
+RETURNING id, author_id, post_id, content, created_at;
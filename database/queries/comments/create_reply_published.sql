@@ -0,0 +1,7 @@
+-- Same as create_reply.sql, also inserting nothing unless the post is published
+INSERT INTO comments (author_id, post_id, content, parent_id)
+SELECT $1, $2, $3, parent.id
+FROM comments parent
+JOIN posts p ON p.id = parent.post_id AND p.status = 'published'
+WHERE parent.id = $4 AND parent.post_id = $2
+RETURNING id, author_id, post_id, content, created_at;
@@ -0,0 +1,7 @@
+-- Same as create_reply_published.sql with created_at supplied by the app (MONOTONIC_CREATED_AT)
+INSERT INTO comments (author_id, post_id, content, parent_id, created_at)
+SELECT $1, $2, $3, parent.id, $5
+FROM comments parent
+JOIN posts p ON p.id = parent.post_id AND p.status = 'published'
+WHERE parent.id = $4 AND parent.post_id = $2
+RETURNING id, author_id, post_id, content, created_at;
@@ -0,0 +1 @@
+DELETE FROM comments WHERE post_id = $1;
@@ -0,0 +1 @@
+SELECT author_id FROM comments WHERE id = $1 AND post_id = $2;
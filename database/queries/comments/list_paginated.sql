@@ -0,0 +1,6 @@
+-- Same as list.sql with optional paging; a NULL limit returns every comment
+SELECT id, author_id, post_id, content, created_at
+FROM comments
+WHERE post_id = $1
+ORDER BY created_at ASC
+LIMIT $2 OFFSET $3;
@@ -0,0 +1,6 @@
+-- Every comment of a post in one pass, grouped by parent for tree assembly
+SELECT id, author_id, post_id, content, created_at, parent_id
+FROM comments
+WHERE post_id = $1
+ORDER BY parent_id NULLS FIRST, created_at ASC
+LIMIT $2;
@@ -0,0 +1,8 @@
+-- Same as list_paginated.sql with the author row joined in for ?expand=author
+SELECT c.id, c.author_id, c.post_id, c.content, c.created_at,
+       u.username, u.email, u.bio, u.created_at AS author_created_at
+FROM comments c
+JOIN users u ON u.id = c.author_id
+WHERE c.post_id = $1
+ORDER BY c.created_at ASC
+LIMIT $2 OFFSET $3;
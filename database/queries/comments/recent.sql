@@ -0,0 +1,7 @@
+-- Newest comments site-wide; comments on drafts stay hidden with their post
+SELECT c.id, c.author_id, c.post_id, c.content, c.created_at, u.username
+FROM comments c
+JOIN users u ON u.id = c.author_id
+JOIN posts p ON p.id = c.post_id AND p.status = 'published'
+ORDER BY c.created_at DESC
+LIMIT $1;
@@ -0,0 +1,10 @@
+-- Replies to $1's comments by other users, newer than their last_seen_replies_at
+SELECT r.id, r.author_id, r.post_id, r.content, r.created_at, r.parent_id
+FROM comments r
+JOIN comments parent ON parent.id = r.parent_id
+JOIN users u ON u.id = parent.author_id
+WHERE parent.author_id = $1
+  AND r.author_id <> $1
+  AND r.created_at > COALESCE(u.last_seen_replies_at, '-infinity'::timestamptz)
+ORDER BY r.created_at DESC
+LIMIT $2 OFFSET $3;
@@ -0,0 +1,5 @@
+UPDATE comments
+SET content = $3,
+    updated_at = NOW()
+WHERE id = $1 AND post_id = $2
+RETURNING id, author_id, post_id, content, created_at, updated_at;
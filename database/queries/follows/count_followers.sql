@@ -0,0 +1 @@
+SELECT COUNT(*) FROM follows WHERE followee_id = $1;
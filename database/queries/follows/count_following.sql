@@ -0,0 +1 @@
+SELECT COUNT(*) FROM follows WHERE follower_id = $1;
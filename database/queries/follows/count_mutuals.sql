@@ -0,0 +1,4 @@
+SELECT COUNT(*)
+FROM follows f
+JOIN follows back ON back.follower_id = f.followee_id AND back.followee_id = f.follower_id
+WHERE f.follower_id = $1;
@@ -0,0 +1,6 @@
+SELECT u.id, u.username, u.email, u.bio, u.created_at
+FROM follows f
+JOIN users u ON u.id = f.follower_id
+WHERE f.followee_id = $1
+ORDER BY f.created_at DESC
+LIMIT $2 OFFSET $3;
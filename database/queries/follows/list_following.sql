@@ -0,0 +1,6 @@
+SELECT u.id, u.username, u.email, u.bio, u.created_at
+FROM follows f
+JOIN users u ON u.id = f.followee_id
+WHERE f.follower_id = $1
+ORDER BY f.created_at DESC
+LIMIT $2 OFFSET $3;
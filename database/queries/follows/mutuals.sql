@@ -0,0 +1,8 @@
+-- Users $1 follows who follow $1 back, most recently mutual first
+SELECT u.id, u.username, u.email, u.bio, u.created_at
+FROM follows f
+JOIN follows back ON back.follower_id = f.followee_id AND back.followee_id = f.follower_id
+JOIN users u ON u.id = f.followee_id
+WHERE f.follower_id = $1
+ORDER BY GREATEST(f.created_at, back.created_at) DESC, u.id
+LIMIT $2 OFFSET $3;
@@ -0,0 +1,9 @@
+-- Most used hashtags among published posts newer than $1 seconds
+SELECT h.tag, COUNT(*)::bigint AS posts
+FROM post_hashtags h
+JOIN posts p ON p.id = h.post_id
+WHERE h.created_at > NOW() - make_interval(secs => $1)
+  AND p.status = 'published'
+GROUP BY h.tag
+ORDER BY posts DESC, h.tag
+LIMIT $2;
@@ -0,0 +1 @@
+SELECT COUNT(*) FROM post_likes WHERE user_id = $1;
@@ -0,0 +1 @@
+SELECT COALESCE(SUM(likes_count), 0)::bigint FROM posts WHERE author_id = $1 AND status = 'published';
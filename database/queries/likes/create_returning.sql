@@ -0,0 +1,4 @@
+INSERT INTO post_likes (user_id, post_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING
+RETURNING user_id, post_id, created_at;
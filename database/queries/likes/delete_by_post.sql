@@ -0,0 +1 @@
+DELETE FROM post_likes WHERE post_id = $1;
@@ -0,0 +1,7 @@
+-- Most recent likers of a post
+SELECT u.id, u.username, l.created_at
+FROM post_likes l
+JOIN users u ON u.id = l.user_id
+WHERE l.post_id = $1
+ORDER BY l.created_at DESC
+LIMIT $2;
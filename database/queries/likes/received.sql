@@ -0,0 +1,10 @@
+-- Likes by other users on $1's posts, optionally newer than $2
+SELECT l.post_id, l.created_at, u.id, u.username
+FROM post_likes l
+JOIN posts p ON p.id = l.post_id
+JOIN users u ON u.id = l.user_id
+WHERE p.author_id = $1
+  AND l.user_id <> $1
+  AND ($2::timestamptz IS NULL OR l.created_at > $2::timestamptz)
+ORDER BY l.created_at DESC
+LIMIT $3 OFFSET $4;
@@ -0,0 +1,3 @@
+SELECT post_id::text
+FROM post_likes
+WHERE post_id = ANY($1::uuid[]) AND user_id = $2;
@@ -0,0 +1 @@
+SELECT COUNT(*) FROM posts WHERE author_id = $1 AND status = 'published';
@@ -0,0 +1 @@
+SELECT COUNT(*)::bigint FROM posts WHERE author_id = $1 AND status = 'draft';
@@ -0,0 +1,4 @@
+-- Pinned posts of an author, not counting the post being pinned
+SELECT COUNT(*)
+FROM posts
+WHERE author_id = $1 AND pinned AND id <> $2;
@@ -0,0 +1,5 @@
+-- Total behind GET /posts for RANGE_HEADERS; $1 mirrors ?minLikes
+SELECT COUNT(*)::bigint
+FROM posts
+WHERE status = 'published'
+  AND ($1::bigint IS NULL OR likes_count >= $1);
@@ -0,0 +1,4 @@
+-- Same as create.sql with created_at supplied by the app (MONOTONIC_CREATED_AT)
+INSERT INTO posts (author_id, content, created_at)
+VALUES ($1, $2, $3)
+RETURNING id, author_id, content, created_at;
@@ -0,0 +1,3 @@
+INSERT INTO posts (author_id, content)
+VALUES ($1, $2)
+RETURNING id;
@@ -0,0 +1,3 @@
+INSERT INTO posts (author_id, content, status, published_at)
+VALUES ($1, $2, $3, CASE WHEN $3 = 'published' THEN now() END)
+RETURNING id, author_id, content, created_at;
@@ -0,0 +1,4 @@
+-- Same as create_with_status.sql with created_at supplied by the app (MONOTONIC_CREATED_AT)
+INSERT INTO posts (author_id, content, status, published_at, created_at)
+VALUES ($1, $2, $3, CASE WHEN $3 = 'published' THEN now() END, $4)
+RETURNING id, author_id, content, created_at;
@@ -0,0 +1,6 @@
+SELECT EXISTS (
+  SELECT 1
+  FROM posts
+  WHERE author_id = $1
+    AND content_hash = sha256(convert_to($2, 'UTF8'))
+);
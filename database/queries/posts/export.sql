@@ -0,0 +1,9 @@
+-- Every post, drafts included; status tells them apart for a re-import
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count,
+       p.status
+FROM posts p
+ORDER BY p.created_at, p.id;
@@ -0,0 +1,28 @@
+-- Recent posts of followed users, grouped by author.
+-- $1 = follower id, $2 = max authors, $3 = max posts per author
+WITH ranked AS (
+    SELECT p.id,
+           p.author_id,
+           p.content,
+           p.created_at,
+           p.likes_count,
+           ROW_NUMBER() OVER (PARTITION BY p.author_id ORDER BY p.created_at DESC) AS rn
+    FROM posts p
+    JOIN follows f ON f.followee_id = p.author_id
+    WHERE f.follower_id = $1
+      AND p.status = 'published'
+),
+top_authors AS (
+    SELECT author_id, created_at AS latest
+    FROM ranked
+    WHERE rn = 1
+    ORDER BY created_at DESC
+    LIMIT $2
+)
+SELECT u.id, u.username, u.email, u.bio, u.created_at,
+       r.id, r.author_id, r.content, r.created_at, r.likes_count::bigint AS like_count
+FROM ranked r
+JOIN top_authors t ON t.author_id = r.author_id
+JOIN users u ON u.id = r.author_id
+WHERE r.rn <= $3
+ORDER BY t.latest DESC, r.author_id, r.created_at DESC;
@@ -0,0 +1,16 @@
+-- Followed users' published posts that $1 has not marked viewed
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count
+FROM follows f
+JOIN posts p ON p.author_id = f.followee_id
+WHERE f.follower_id = $1
+  AND p.status = 'published'
+  AND NOT EXISTS (
+      SELECT 1 FROM post_views v
+      WHERE v.user_id = $1 AND v.post_id = p.id
+  )
+ORDER BY p.created_at DESC
+LIMIT $2 OFFSET $3;
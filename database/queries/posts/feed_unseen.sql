@@ -0,0 +1,14 @@
+-- Followed users' posts newer than $1's last_feed_seen_at
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count
+FROM follows f
+JOIN users u ON u.id = f.follower_id
+JOIN posts p ON p.author_id = f.followee_id
+WHERE f.follower_id = $1
+  AND p.status = 'published'
+  AND p.created_at > COALESCE(u.last_feed_seen_at, '-infinity'::timestamptz)
+ORDER BY p.created_at DESC
+LIMIT $2 OFFSET $3;
@@ -18,4 +18,5 @@ SELECT p.id,
        p.created_at,
        p.likes_count::bigint AS like_count
 FROM posts p
-WHERE p.id = $1;
\ No newline at end of file
+WHERE p.id = $1
+  AND p.status = 'published';
\ No newline at end of file
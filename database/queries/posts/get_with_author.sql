@@ -0,0 +1,10 @@
+-- Same as get.sql, skipping posts whose author row is gone (HIDE_ORPHAN_POSTS)
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count
+FROM posts p
+JOIN users u ON u.id = p.author_id
+WHERE p.id = $1
+  AND p.status = 'published';
@@ -0,0 +1 @@
+SELECT MAX(created_at) FROM posts WHERE author_id = $1;
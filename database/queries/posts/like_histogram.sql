@@ -0,0 +1,11 @@
+-- Posts per like-count bucket; empty buckets are absent
+SELECT CASE
+         WHEN likes_count = 0 THEN '0'
+         WHEN likes_count < 10 THEN '1-9'
+         WHEN likes_count < 100 THEN '10-99'
+         ELSE '100+'
+       END AS bucket,
+       COUNT(*)::bigint AS posts
+FROM posts
+WHERE status = 'published'
+GROUP BY bucket;
@@ -19,5 +19,6 @@ SELECT p.id,
        p.created_at,
        p.likes_count::bigint AS like_count
 FROM posts p
+WHERE p.status = 'published'
 ORDER BY p.created_at DESC
 LIMIT $1 OFFSET $2;
\ No newline at end of file
@@ -0,0 +1,12 @@
+-- An author's posts, pinned ones first
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count,
+       p.pinned
+FROM posts p
+WHERE p.author_id = $1
+  AND p.status = 'published'
+ORDER BY p.pinned DESC, p.created_at DESC
+LIMIT $2 OFFSET $3;
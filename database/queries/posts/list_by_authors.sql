@@ -0,0 +1,11 @@
+-- Posts by any of the given authors, newest first
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count
+FROM posts p
+WHERE p.author_id = ANY($1::uuid[])
+  AND p.status = 'published'
+ORDER BY p.created_at DESC
+LIMIT $2 OFFSET $3;
@@ -0,0 +1,11 @@
+SELECT DISTINCT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count
+FROM comments c
+JOIN posts p ON p.id = c.post_id
+WHERE c.author_id = $1
+  AND p.status = 'published'
+ORDER BY p.created_at DESC
+LIMIT $2 OFFSET $3;
@@ -0,0 +1,9 @@
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count
+FROM posts p
+WHERE p.author_id = $1 AND p.status = 'draft'
+ORDER BY p.created_at DESC
+LIMIT $2 OFFSET $3;
@@ -0,0 +1,15 @@
+-- Keyset page for SNAPSHOT_PAGINATION: $1 is the snapshot bound (newest created_at
+-- when the scan started), $2/$3 the last (created_at, id) served. All NULL on page one.
+-- $5 is an optional minimum like count (?minLikes).
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count
+FROM posts p
+WHERE p.status = 'published'
+  AND ($5::bigint IS NULL OR p.likes_count >= $5)
+  AND ($1::timestamptz IS NULL
+       OR (p.created_at <= $1 AND (p.created_at, p.id) < ($2::timestamptz, $3::uuid)))
+ORDER BY p.created_at DESC, p.id DESC
+LIMIT $4;
@@ -0,0 +1,14 @@
+-- Same as list_keyset.sql, skipping posts whose author row is gone (HIDE_ORPHAN_POSTS)
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count
+FROM posts p
+JOIN users u ON u.id = p.author_id
+WHERE p.status = 'published'
+  AND ($5::bigint IS NULL OR p.likes_count >= $5)
+  AND ($1::timestamptz IS NULL
+       OR (p.created_at <= $1 AND (p.created_at, p.id) < ($2::timestamptz, $3::uuid)))
+ORDER BY p.created_at DESC, p.id DESC
+LIMIT $4;
@@ -0,0 +1,13 @@
+-- {{ORDER_BY}} is replaced with an ORDER BY list built from a whitelist of
+-- sortable columns; user input is never interpolated. $3 is an optional minimum
+-- like count (?minLikes).
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count
+FROM posts p
+WHERE p.status = 'published'
+  AND ($3::bigint IS NULL OR p.likes_count >= $3)
+ORDER BY {{ORDER_BY}}
+LIMIT $1 OFFSET $2;
@@ -0,0 +1,6 @@
+-- Change fingerprint for the posts list: any insert, delete or like moves one of these
+SELECT COUNT(*)::bigint,
+       COALESCE(MAX(created_at), 'epoch'),
+       COALESCE(SUM(likes_count), 0)::bigint
+FROM posts
+WHERE status = 'published';
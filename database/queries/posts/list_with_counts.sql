@@ -0,0 +1,19 @@
+-- list.sql plus a per-row comment count. Likes come from the likes_count counter;
+-- comments are counted through a lateral join so each page row costs one index probe.
+-- {{ORDER_BY}} is replaced as in list_sorted.sql.
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count,
+       cc.cnt AS comment_count
+FROM posts p
+LEFT JOIN LATERAL (
+    SELECT COUNT(*)::bigint AS cnt
+    FROM comments c
+    WHERE c.post_id = p.id
+) cc ON TRUE
+WHERE p.status = 'published'
+  AND ($3::bigint IS NULL OR p.likes_count >= $3)
+ORDER BY {{ORDER_BY}}
+LIMIT $1 OFFSET $2;
@@ -0,0 +1,10 @@
+-- Posts for the given ids, in the order the ids were passed
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count
+FROM posts p
+WHERE p.id = ANY($1::uuid[])
+  AND p.status = 'published'
+ORDER BY array_position($1::uuid[], p.id);
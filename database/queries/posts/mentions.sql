@@ -0,0 +1,14 @@
+-- Posts mentioning @username, newest first. ILIKE with a leading wildcard cannot use a
+-- btree index, so this scans posts; a parsed mentions table would make it an index lookup.
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count
+FROM users u
+JOIN posts p
+  ON p.content ILIKE '%@' || replace(replace(replace(u.username, '\', '\\'), '%', '\%'), '_', '\_') || '%'
+WHERE u.id = $1
+  AND p.status = 'published'
+ORDER BY p.created_at DESC
+LIMIT $2 OFFSET $3;
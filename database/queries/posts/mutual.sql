@@ -0,0 +1,16 @@
+-- Posts by users that both $1 and $2 follow
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count
+FROM posts p
+WHERE p.status = 'published'
+  AND p.author_id IN (
+    SELECT fa.followee_id
+    FROM follows fa
+    JOIN follows fb ON fb.followee_id = fa.followee_id
+    WHERE fa.follower_id = $1 AND fb.follower_id = $2
+)
+ORDER BY p.created_at DESC
+LIMIT $3 OFFSET $4;
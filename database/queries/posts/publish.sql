@@ -0,0 +1,4 @@
+UPDATE posts
+SET status = 'published', published_at = now()
+WHERE id = $1 AND status = 'draft'
+RETURNING id, author_id, content, created_at, likes_count::bigint AS like_count, published_at;
@@ -0,0 +1,10 @@
+-- Uniform random sample; sorts the whole table, so cost grows with its size
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count
+FROM posts p
+WHERE p.status = 'published'
+ORDER BY random()
+LIMIT $1;
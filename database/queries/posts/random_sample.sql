@@ -0,0 +1,11 @@
+-- Block-level sample of $2 percent of the table, then shuffled; cheap on large tables
+-- but may return fewer than $1 rows when the table is small
+SELECT p.id,
+       p.author_id,
+       p.content,
+       p.created_at,
+       p.likes_count::bigint AS like_count
+FROM posts p TABLESAMPLE SYSTEM ($2)
+WHERE p.status = 'published'
+ORDER BY random()
+LIMIT $1;
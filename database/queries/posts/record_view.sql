@@ -0,0 +1,3 @@
+INSERT INTO post_views (user_id, post_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+-- Run after likes/delete_by_post.sql; the per-row triggers already got the counter to
+-- zero, this also repairs any drift
+UPDATE posts SET likes_count = 0 WHERE id = $1;
@@ -0,0 +1,3 @@
+UPDATE posts
+SET pinned = $2
+WHERE id = $1;
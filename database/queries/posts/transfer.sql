@@ -0,0 +1,4 @@
+UPDATE posts
+SET author_id = $2
+WHERE id = $1
+RETURNING id, author_id, content, created_at, likes_count::bigint AS like_count;
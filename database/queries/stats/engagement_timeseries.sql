@@ -0,0 +1,33 @@
+-- Posts, comments and likes per $1 bucket ('hour', 'day' or 'week') over the last $2
+-- days. generate_series supplies every bucket so quiet periods come back as zeros.
+WITH bounds AS (
+    SELECT date_trunc($1, NOW() - make_interval(days => $2)) AS since,
+           ('1 ' || $1)::interval AS step
+), buckets AS (
+    SELECT generate_series(b.since, date_trunc($1, NOW()), b.step) AS bucket
+    FROM bounds b
+), p AS (
+    SELECT date_trunc($1, created_at) AS bucket, COUNT(*) AS n
+    FROM posts
+    WHERE created_at >= (SELECT since FROM bounds) AND status = 'published'
+    GROUP BY 1
+), c AS (
+    SELECT date_trunc($1, created_at) AS bucket, COUNT(*) AS n
+    FROM comments
+    WHERE created_at >= (SELECT since FROM bounds)
+    GROUP BY 1
+), l AS (
+    SELECT date_trunc($1, created_at) AS bucket, COUNT(*) AS n
+    FROM post_likes
+    WHERE created_at >= (SELECT since FROM bounds)
+    GROUP BY 1
+)
+SELECT b.bucket,
+       COALESCE(p.n, 0)::bigint AS posts,
+       COALESCE(c.n, 0)::bigint AS comments,
+       COALESCE(l.n, 0)::bigint AS likes
+FROM buckets b
+LEFT JOIN p ON p.bucket = b.bucket
+LEFT JOIN c ON c.bucket = b.bucket
+LEFT JOIN l ON l.bucket = b.bucket
+ORDER BY b.bucket;
@@ -0,0 +1,6 @@
+-- Exact counts for dataset verification; these scan every table, so admin use only
+SELECT (SELECT COUNT(*) FROM users)::bigint,
+       (SELECT COUNT(*) FROM posts)::bigint,
+       (SELECT COUNT(*) FROM comments)::bigint,
+       (SELECT COUNT(*) FROM post_likes)::bigint,
+       (SELECT COUNT(*) FROM follows)::bigint;
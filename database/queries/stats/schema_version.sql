@@ -0,0 +1 @@
+SELECT MAX(version) FROM schema_migrations;
@@ -0,0 +1,21 @@
+-- A user's posts, comments and likes as one timeline.
+-- $1 = user id, $2-$4 = exclusive (created_at, type, key) cursor (NULLs for newest), $5 = limit.
+-- key is the row's own id, or the liked post for likes, so (type, key) breaks created_at ties.
+SELECT a.type, a.id, a.post_id, a.content, a.created_at, a.key
+FROM (
+    SELECT 'post' AS type, p.id, p.id AS post_id, p.content, p.created_at, p.id AS key
+    FROM posts p
+    WHERE p.author_id = $1
+    UNION ALL
+    SELECT 'comment', c.id, c.post_id, c.content, c.created_at, c.id
+    FROM comments c
+    WHERE c.author_id = $1
+    UNION ALL
+    SELECT 'like', NULL, l.post_id, NULL, l.created_at, l.post_id
+    FROM post_likes l
+    WHERE l.user_id = $1
+) a
+WHERE $2::timestamptz IS NULL
+   OR (a.created_at, a.type, a.key) < ($2::timestamptz, $3::text, $4::uuid)
+ORDER BY a.created_at DESC, a.type DESC, a.key DESC
+LIMIT $5;
@@ -0,0 +1,2 @@
+-- Every admin id, for labelling isAdmin on users shown to an admin
+SELECT id FROM users WHERE is_admin = TRUE;
@@ -0,0 +1 @@
+SELECT COUNT(*)::bigint FROM users;
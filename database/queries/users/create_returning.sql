@@ -0,0 +1,3 @@
+INSERT INTO users (username, email, password_hash, bio)
+VALUES ($1, $2, $3, $4)
+RETURNING id, username, email, bio, created_at;
@@ -0,0 +1 @@
+UPDATE users SET must_reset_password = TRUE WHERE id = $1;
@@ -0,0 +1,4 @@
+SELECT u.id, u.username, u.email, u.bio, u.created_at
+FROM posts p
+JOIN users u ON u.id = p.author_id
+WHERE p.id = $1;
@@ -0,0 +1 @@
+SELECT notification_prefs FROM users WHERE id = $1;
@@ -0,0 +1,8 @@
+-- Users who have never posted
+SELECT u.id, u.username, u.email, u.bio, u.created_at
+FROM users u
+WHERE NOT EXISTS (
+  SELECT 1 FROM posts p WHERE p.author_id = u.id
+)
+ORDER BY u.created_at DESC
+LIMIT $1 OFFSET $2;
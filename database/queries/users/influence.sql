@@ -0,0 +1,6 @@
+-- Inputs to the influence score; no row when the user does not exist
+SELECT (SELECT COUNT(*) FROM follows f WHERE f.followee_id = u.id)::bigint AS followers,
+       (SELECT COALESCE(SUM(p.likes_count), 0) FROM posts p WHERE p.author_id = u.id AND p.status = 'published')::bigint AS likes_received,
+       (SELECT COUNT(*) FROM posts p WHERE p.author_id = u.id AND p.status = 'published')::bigint AS posts
+FROM users u
+WHERE u.id = $1;
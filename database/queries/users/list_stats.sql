@@ -0,0 +1,5 @@
+-- Change fingerprint for the users list: any insert, delete or update moves one of these
+SELECT COUNT(*)::bigint,
+       COALESCE(MAX(created_at), 'epoch'),
+       COALESCE(SUM(version), 0)::bigint
+FROM users;
@@ -0,0 +1,4 @@
+UPDATE users
+SET last_feed_seen_at = NOW()
+WHERE id = $1
+RETURNING last_feed_seen_at;
@@ -0,0 +1,4 @@
+UPDATE users
+SET last_seen_replies_at = NOW()
+WHERE id = $1
+RETURNING last_seen_replies_at;
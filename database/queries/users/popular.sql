@@ -0,0 +1,7 @@
+-- Users ranked by follower count
+SELECT u.id, u.username, u.email, u.bio, u.created_at, COUNT(*)::bigint AS followers
+FROM follows f
+JOIN users u ON u.id = f.followee_id
+GROUP BY u.id
+ORDER BY followers DESC, u.id
+LIMIT $1;
@@ -0,0 +1,5 @@
+SELECT id, username
+FROM users
+WHERE username LIKE $1 || '%'
+ORDER BY username
+LIMIT $2;
@@ -0,0 +1 @@
+SELECT email FROM users WHERE email = ANY($1);
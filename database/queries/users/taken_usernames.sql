@@ -0,0 +1 @@
+SELECT username FROM users WHERE username = ANY($1);
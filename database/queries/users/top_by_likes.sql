@@ -0,0 +1,7 @@
+-- Users ranked by the total likes received across all their posts
+SELECT u.id, u.username, u.email, u.bio, u.created_at, SUM(p.likes_count)::bigint AS total_likes
+FROM users u
+JOIN posts p ON p.author_id = u.id AND p.status = 'published'
+GROUP BY u.id
+ORDER BY total_likes DESC, u.id
+LIMIT $1;
@@ -1,5 +1,6 @@
 UPDATE users
-SET bio = $2
+SET bio = $2,
+    version = version + 1
 WHERE id = $1
 RETURNING id, username, email, bio, created_at;
 
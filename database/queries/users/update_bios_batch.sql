@@ -0,0 +1,6 @@
+-- Bulk bio backfill: $1 user ids and $2 bios, matched by position
+UPDATE users u
+SET bio = v.bio,
+    version = u.version + 1
+FROM unnest($1::uuid[], $2::text[]) AS v(id, bio)
+WHERE u.id = v.id;
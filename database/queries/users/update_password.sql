@@ -0,0 +1,4 @@
+UPDATE users
+SET password_hash = $2,
+    must_reset_password = FALSE
+WHERE id = $1;
@@ -0,0 +1,4 @@
+UPDATE users
+SET notification_prefs = $2
+WHERE id = $1
+RETURNING notification_prefs;
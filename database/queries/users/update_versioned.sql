@@ -0,0 +1,7 @@
+-- $3 is the expected row version; NULL skips the check
+UPDATE users
+SET bio = $2,
+    version = version + 1
+WHERE id = $1
+  AND ($3::bigint IS NULL OR version = $3)
+RETURNING id, username, email, bio, created_at, version;
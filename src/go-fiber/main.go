@@ -1,32 +1,123 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"math"
+	"math/rand/v2"
+	"mime"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
 	"runtime/pprof"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/etag"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/sync/singleflight"
 )
 
 var (
 	DATABASE_URL       = os.Getenv("DATABASE_URL")
 	JWT_SECRET         = os.Getenv("JWT_SECRET")
 	JWT_EXPIRE_MINUTES = getenvInt("JWT_EXPIRE_MINUTES", 60)
+
+	ENABLE_INSTANCE_HEADER  = getenvBool("ENABLE_INSTANCE_HEADER", false)
+	STREAM_LISTS            = getenvBool("STREAM_LISTS", false)
+	POSTS_CACHE_TTL_MS      = getenvInt("POSTS_CACHE_TTL_MS", 0)
+	MIN_BCRYPT_COST         = getenvInt("MIN_BCRYPT_COST", 0)
+	LOGIN_RETRY_ON_DB_ERROR = getenvBool("LOGIN_RETRY_ON_DB_ERROR", false)
+	REQUEST_DEADLINE_MS     = getenvInt("REQUEST_DEADLINE_MS", 0)
+	MAX_LIKES_PER_USER      = getenvInt("MAX_LIKES_PER_USER", 0)
+	CAPTURE_SAMPLE_RATE     = getenvFloat("CAPTURE_SAMPLE_RATE", 0)
+	CAPTURE_DIR             = os.Getenv("CAPTURE_DIR")
+	DEFAULT_LIMITS          = parseDefaultLimits(os.Getenv("DEFAULT_LIMITS"))
+	INSERT_THEN_SELECT      = getenvBool("INSERT_THEN_SELECT", false)
+	POST_COOLDOWN_MS        = getenvInt("POST_COOLDOWN_MS", 0)
+	PASSWORD_POLICY         = os.Getenv("PASSWORD_POLICY")
+	PASSWORD_MIN_LENGTH     = getenvInt("PASSWORD_MIN_LENGTH", 8)
+	DB_VALIDATE_ON_ACQUIRE  = getenvBool("DB_VALIDATE_ON_ACQUIRE", false)
+	RESPONSE_FORMAT         = os.Getenv("RESPONSE_FORMAT")
+	OMIT_NULLS              = getenvBool("OMIT_NULLS", false)
+	ALLOWED_HOSTS           = parseList(os.Getenv("ALLOWED_HOSTS"))
+	RETRY_AFTER_JITTER_SEC  = getenvInt("RETRY_AFTER_JITTER_SEC", 0)
+	TIMESTAMP_FORMAT        = os.Getenv("TIMESTAMP_FORMAT")
+	COMMENT_BATCH_MS        = getenvInt("COMMENT_BATCH_MS", 0)
+	MAX_FOLLOWING           = getenvInt("MAX_FOLLOWING", 0)
+	DEDUP_POSTS             = getenvBool("DEDUP_POSTS", false)
+	DB_QUERY_TIMEOUT_MS     = getenvInt("DB_QUERY_TIMEOUT_MS", 0)
+	ROUTE_TIMEOUTS          = parseDefaultLimits(os.Getenv("ROUTE_TIMEOUTS"))
+	LIST_ETAGS              = os.Getenv("LIST_ETAGS")
+	READ_HEADER_TIMEOUT_MS  = getenvInt("READ_HEADER_TIMEOUT_MS", 10000)
+	STRICT_CONTENT_TYPE     = getenvBool("STRICT_CONTENT_TYPE", false)
+	DB_SSLMODE              = os.Getenv("DB_SSLMODE")
+	DB_SERVER_TIMEOUT       = getenvBool("DB_SERVER_TIMEOUT", false)
+	ENABLE_TRACING          = getenvBool("ENABLE_TRACING", false)
+	HIDE_ORPHAN_POSTS       = getenvBool("HIDE_ORPHAN_POSTS", false)
+	DB_BREAKER_THRESHOLD    = getenvInt("DB_BREAKER_THRESHOLD", 0)
+	DB_BREAKER_COOLDOWN_MS  = getenvInt("DB_BREAKER_COOLDOWN_MS", 5000)
+	PASSWORD_RESET_TTL_MIN  = getenvInt("PASSWORD_RESET_TTL_MIN", 30)
+	NO_CONTENT_AS_200       = getenvBool("NO_CONTENT_AS_200", false)
+	SNAPSHOT_PAGINATION     = getenvBool("SNAPSHOT_PAGINATION", false)
+	MAX_COMMENTS_PER_POST   = getenvInt("MAX_COMMENTS_PER_POST", 0)
+	// Requests per minute per client IP on POST /auth/check-availability; 0 disables the limit
+	AVAILABILITY_RATE_LIMIT = getenvInt("AVAILABILITY_RATE_LIMIT", 0)
+	// Logs every query with its (redacted) arguments; a debugging aid, never for production
+	DEBUG_SQL = getenvBool("DEBUG_SQL", false)
+	// Response field naming: "camel" (default, the canonical shape) or "snake"
+	FIELD_CASE          = os.Getenv("FIELD_CASE")
+	MAX_OFFSET          = getenvInt("MAX_OFFSET", 0)
+	ENABLE_SINGLEFLIGHT = getenvBool("ENABLE_SINGLEFLIGHT", false)
+	RANGE_HEADERS       = getenvBool("RANGE_HEADERS", false)
+	PREWARM_QUERY       = getenvBool("PREWARM_QUERY", false)
+	// Most recent passwords, the current one included, refused on change; 0 disables the check
+	PASSWORD_HISTORY_SIZE = getenvInt("PASSWORD_HISTORY_SIZE", 0)
+	// The app stamps created_at on post and comment inserts from a strictly increasing
+	// clock instead of the column default, so keyset pages never split a timestamp tie
+	MONOTONIC_CREATED_AT = getenvBool("MONOTONIC_CREATED_AT", false)
+
+	INFLUENCE_WEIGHT_FOLLOWERS = getenvFloat("INFLUENCE_WEIGHT_FOLLOWERS", 2)
+	INFLUENCE_WEIGHT_LIKES     = getenvFloat("INFLUENCE_WEIGHT_LIKES", 1)
+	INFLUENCE_WEIGHT_POSTS     = getenvFloat("INFLUENCE_WEIGHT_POSTS", 0.5)
+	SSE_MAX_SUBSCRIBERS        = getenvInt("SSE_MAX_SUBSCRIBERS", 100)
 )
 
 func getenvInt(key string, fallback int) int {
@@ -41,12 +132,80 @@ func getenvInt(key string, fallback int) int {
 	return i
 }
 
+func getenvBool(key string, fallback bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fallback
+	}
+	return b
+}
+
+func getenvFloat(key string, fallback float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fallback
+	}
+	return f
+}
+
+// parseList splits a comma-separated env value, dropping blanks.
+func parseList(spec string) []string {
+	var items []string
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseDefaultLimits reads per-route page sizes such as "posts:20,comments:50".
+// Malformed entries are skipped.
+func parseDefaultLimits(spec string) map[string]int {
+	limits := make(map[string]int)
+	for _, entry := range strings.Split(spec, ",") {
+		route, value, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n <= 0 {
+			continue
+		}
+		limits[strings.TrimSpace(route)] = n
+	}
+	return limits
+}
+
+// instanceID identifies this server process, preferring INSTANCE_ID over the hostname.
+func instanceID() string {
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		return id
+	}
+	if host, err := os.Hostname(); err == nil {
+		return host
+	}
+	return "unknown"
+}
+
+// sqlFileNames maps loaded query text back to its file, naming queries in DEBUG_SQL logs.
+var sqlFileNames = map[string]string{}
+
 func loadSQL(relative string) (string, error) {
 	if base := os.Getenv("QUERIES_DIR"); base != "" {
 		b, err := os.ReadFile(filepath.Join(base, relative))
 		if err != nil {
 			return "", err
 		}
+		sqlFileNames[string(b)] = relative
 		return string(b), nil
 	}
 	cwd, _ := os.Getwd()
@@ -56,27 +215,152 @@ func loadSQL(relative string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("SQL file not found: %s", path)
 	}
+	sqlFileNames[string(b)] = relative
 	return string(b), nil
 }
 
+// insertFile swaps an insert for its _at variant, which takes created_at as an extra
+// last parameter, when MONOTONIC_CREATED_AT is on.
+func insertFile(relative string) string {
+	if !MONOTONIC_CREATED_AT {
+		return relative
+	}
+	return strings.TrimSuffix(relative, ".sql") + "_at.sql"
+}
+
+// createdAtClock hands out strictly increasing insert timestamps. Postgres keeps
+// microseconds, so two inserts never share a created_at from one instance.
+type createdAtClock struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+var insertClock createdAtClock
+
+func (c *createdAtClock) next() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	if !now.After(c.last) {
+		now = c.last.Add(time.Microsecond)
+	}
+	c.last = now
+	return now
+}
+
+// withCreatedAt appends the created_at argument expected by insertFile variants.
+func withCreatedAt(args ...any) []any {
+	if !MONOTONIC_CREATED_AT {
+		return args
+	}
+	return append(args, insertClock.next())
+}
+
 var (
-	SQL_LOGIN          string
-	SQL_ME             string
-	SQL_CREATE_USER    string
-	SQL_GET_USER       string
-	SQL_LIST_USERS     string
-	SQL_UPDATE_USER    string
-	SQL_DELETE_USER    string
-	SQL_CREATE_POST    string
-	SQL_LIST_POSTS     string
-	SQL_GET_POST       string
-	SQL_GET_POST_AUTH  string
-	SQL_DELETE_POST    string
-	SQL_CREATE_COMMENT string
-	SQL_LIST_COMMENTS  string
-	SQL_LIKE_EXISTS    string
-	SQL_CREATE_LIKE    string
-	SQL_DELETE_LIKE    string
+	SQL_LOGIN             string
+	SQL_CREATE_USER       string
+	SQL_CREATE_USER_R     string
+	SQL_GET_USER          string
+	SQL_LIST_USERS        string
+	SQL_UPDATE_USER       string
+	SQL_UPDATE_USER_V     string
+	SQL_USER_EXISTS       string
+	SQL_FLAG_RESET        string
+	SQL_GET_PW_HASH       string
+	SQL_UPDATE_PW         string
+	SQL_USER_ACTIVITY     string
+	SQL_TOP_BY_LIKES      string
+	SQL_DELETE_USER       string
+	SQL_CREATE_POST       string
+	SQL_LIST_POSTS        string
+	SQL_LIST_KEYSET       string
+	SQL_LIST_SORTED       string
+	SQL_LIST_COMMENTED    string
+	SQL_FEED_DIGEST       string
+	SQL_MUTUAL_POSTS      string
+	SQL_LOOKUP_POSTS      string
+	SQL_LAST_POST_AT      string
+	SQL_EXPORT_POSTS      string
+	SQL_FEED_UNSEEN       string
+	SQL_FEED_SEEN         string
+	SQL_CREATE_POST_ID    string
+	SQL_TRANSFER_POST     string
+	SQL_SUGGEST_USERS     string
+	SQL_POSTS_BY_AUTHORS  string
+	SQL_DUPLICATE_POST    string
+	SQL_INACTIVE_USERS    string
+	SQL_UPDATE_BIOS       string
+	SQL_POSTS_STATS       string
+	SQL_SET_PINNED        string
+	SQL_COUNT_PINNED      string
+	SQL_POSTS_BY_AUTHOR   string
+	SQL_COMMENT_TREE      string
+	SQL_MENTIONS          string
+	SQL_RANDOM_POSTS      string
+	SQL_INFLUENCE         string
+	SQL_LIKE_HISTOGRAM    string
+	SQL_ME_VERSIONED      string
+	SQL_LIST_COUNTED      string
+	SQL_RESET_CREATE      string
+	SQL_POPULAR_USERS     string
+	SQL_TRENDING_TAGS     string
+	SQL_DELETE_POST_LIKES string
+	SQL_RESET_LIKE_COUNT  string
+	SQL_RESET_CONSUME     string
+	SQL_SAMPLE_POSTS      string
+	SQL_USERS_STATS       string
+	SQL_GET_POST          string
+	SQL_GET_POST_AUTH     string
+	SQL_DELETE_POST       string
+	SQL_CREATE_COMMENT    string
+	SQL_LIST_COMMENTS     string
+	SQL_LIST_COMM_PAGE    string
+	SQL_GET_COMM_AUTH     string
+	SQL_UPDATE_COMMENT    string
+	SQL_CREATE_REPLY      string
+	SQL_UNREAD_REPLIES    string
+	SQL_REPLIES_SEEN      string
+	SQL_RECENT_COMMS      string
+	SQL_DELETE_COMMS      string
+	SQL_LIKE_EXISTS       string
+	SQL_CREATE_LIKE       string
+	SQL_DELETE_LIKE       string
+	SQL_LIKE_STATUS       string
+	SQL_COUNT_LIKES_BY    string
+	SQL_CREATE_LIKE_R     string
+	SQL_LIKES_RECEIVED    string
+	SQL_CREATE_FOLLOW     string
+	SQL_LIST_FOLLOWERS    string
+	SQL_LIST_FOLLOWING    string
+
+	SQL_COUNT_POSTS_BY_AUTHOR  string
+	SQL_COUNT_FOLLOWERS        string
+	SQL_COUNT_FOLLOWING        string
+	SQL_COUNT_LIKES_RECEIVED   string
+	SQL_COUNT_COMMENTS_BY_POST string
+	SQL_CREATE_WITH_STATUS     string
+	SQL_LIST_DRAFTS            string
+	SQL_PUBLISH_POST           string
+	SQL_GET_PREFS              string
+	SQL_UPDATE_PREFS           string
+	SQL_ADMIN_IDS              string
+	SQL_TAKEN_USERNAMES        string
+	SQL_TAKEN_EMAILS           string
+	SQL_FEED_NEW               string
+	SQL_RECORD_VIEW            string
+	SQL_GET_POST_AUTHOR_USER   string
+	SQL_LIST_LIKERS            string
+	SQL_ENGAGEMENT_SERIES      string
+	SQL_COUNT_PUBLISHED        string
+	SQL_COUNT_DRAFTS           string
+	SQL_COUNT_USERS            string
+	SQL_ROW_COUNTS             string
+	SQL_SCHEMA_VERSION         string
+	SQL_PW_HISTORY             string
+	SQL_PW_HISTORY_PUSH        string
+	SQL_LIST_COMM_AUTHOR       string
+	SQL_LIST_MUTUALS           string
+	SQL_COUNT_MUTUALS          string
 )
 
 func mustLoadSQL() {
@@ -84,10 +368,10 @@ func mustLoadSQL() {
 	if SQL_LOGIN, err = loadSQL("auth/login.sql"); err != nil {
 		panic(err)
 	}
-	if SQL_ME, err = loadSQL("auth/me.sql"); err != nil {
+	if SQL_CREATE_USER, err = loadSQL("users/create.sql"); err != nil {
 		panic(err)
 	}
-	if SQL_CREATE_USER, err = loadSQL("users/create.sql"); err != nil {
+	if SQL_CREATE_USER_R, err = loadSQL("users/create_returning.sql"); err != nil {
 		panic(err)
 	}
 	if SQL_GET_USER, err = loadSQL("users/get.sql"); err != nil {
@@ -99,16 +383,151 @@ func mustLoadSQL() {
 	if SQL_UPDATE_USER, err = loadSQL("users/update.sql"); err != nil {
 		panic(err)
 	}
+	if SQL_UPDATE_USER_V, err = loadSQL("users/update_versioned.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_USER_EXISTS, err = loadSQL("users/exists.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_FLAG_RESET, err = loadSQL("users/flag_reset.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_GET_PW_HASH, err = loadSQL("users/get_password_hash.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_UPDATE_PW, err = loadSQL("users/update_password.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_USER_ACTIVITY, err = loadSQL("users/activity.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_TOP_BY_LIKES, err = loadSQL("users/top_by_likes.sql"); err != nil {
+		panic(err)
+	}
 	if SQL_DELETE_USER, err = loadSQL("users/delete.sql"); err != nil {
 		panic(err)
 	}
-	if SQL_CREATE_POST, err = loadSQL("posts/create.sql"); err != nil {
+	if SQL_CREATE_POST, err = loadSQL(insertFile("posts/create.sql")); err != nil {
+		panic(err)
+	}
+	listPostsFile, getPostFile, keysetFile := "posts/list.sql", "posts/get.sql", "posts/list_keyset.sql"
+	if HIDE_ORPHAN_POSTS {
+		// The schema cascades author deletes, so this only matters for data loaded around the FKs
+		listPostsFile, getPostFile = "posts/list_with_author.sql", "posts/get_with_author.sql"
+		keysetFile = "posts/list_keyset_with_author.sql"
+	}
+	if SQL_LIST_POSTS, err = loadSQL(listPostsFile); err != nil {
+		panic(err)
+	}
+	if SQL_LIST_KEYSET, err = loadSQL(keysetFile); err != nil {
+		panic(err)
+	}
+	if SQL_LIST_SORTED, err = loadSQL("posts/list_sorted.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_LIST_COMMENTED, err = loadSQL("posts/list_commented.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_FEED_DIGEST, err = loadSQL("posts/feed_digest.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_MUTUAL_POSTS, err = loadSQL("posts/mutual.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_LOOKUP_POSTS, err = loadSQL("posts/lookup.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_LAST_POST_AT, err = loadSQL("posts/last_by_author.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_EXPORT_POSTS, err = loadSQL("posts/export.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_FEED_UNSEEN, err = loadSQL("posts/feed_unseen.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_FEED_SEEN, err = loadSQL("users/mark_feed_seen.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_CREATE_POST_ID, err = loadSQL(insertFile("posts/create_for_author.sql")); err != nil {
+		panic(err)
+	}
+	if SQL_DUPLICATE_POST, err = loadSQL("posts/duplicate_exists.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_POSTS_BY_AUTHORS, err = loadSQL("posts/list_by_authors.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_SET_PINNED, err = loadSQL("posts/set_pinned.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_COUNT_PINNED, err = loadSQL("posts/count_pinned.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_DELETE_POST_LIKES, err = loadSQL("likes/delete_by_post.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_RESET_LIKE_COUNT, err = loadSQL("posts/reset_likes_count.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_TRENDING_TAGS, err = loadSQL("hashtags/trending.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_POPULAR_USERS, err = loadSQL("users/popular.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_RESET_CREATE, err = loadSQL("auth/reset_create.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_RESET_CONSUME, err = loadSQL("auth/reset_consume.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_LIST_COUNTED, err = loadSQL("posts/list_with_counts.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_ME_VERSIONED, err = loadSQL("auth/me_versioned.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_LIKE_HISTOGRAM, err = loadSQL("posts/like_histogram.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_INFLUENCE, err = loadSQL("users/influence.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_RANDOM_POSTS, err = loadSQL("posts/random.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_SAMPLE_POSTS, err = loadSQL("posts/random_sample.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_MENTIONS, err = loadSQL("posts/mentions.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_COMMENT_TREE, err = loadSQL("comments/list_tree.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_POSTS_BY_AUTHOR, err = loadSQL("posts/list_by_author.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_POSTS_STATS, err = loadSQL("posts/list_stats.sql"); err != nil {
 		panic(err)
 	}
-	if SQL_LIST_POSTS, err = loadSQL("posts/list.sql"); err != nil {
+	if SQL_USERS_STATS, err = loadSQL("users/list_stats.sql"); err != nil {
 		panic(err)
 	}
-	if SQL_GET_POST, err = loadSQL("posts/get.sql"); err != nil {
+	if SQL_UPDATE_BIOS, err = loadSQL("users/update_bios_batch.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_INACTIVE_USERS, err = loadSQL("users/inactive.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_SUGGEST_USERS, err = loadSQL("users/suggest.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_TRANSFER_POST, err = loadSQL("posts/transfer.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_GET_POST, err = loadSQL(getPostFile); err != nil {
 		panic(err)
 	}
 	if SQL_GET_POST_AUTH, err = loadSQL("posts/get_author.sql"); err != nil {
@@ -117,12 +536,36 @@ func mustLoadSQL() {
 	if SQL_DELETE_POST, err = loadSQL("posts/delete.sql"); err != nil {
 		panic(err)
 	}
-	if SQL_CREATE_COMMENT, err = loadSQL("comments/create.sql"); err != nil {
+	if SQL_CREATE_COMMENT, err = loadSQL(insertFile("comments/create_published.sql")); err != nil {
 		panic(err)
 	}
 	if SQL_LIST_COMMENTS, err = loadSQL("comments/list.sql"); err != nil {
 		panic(err)
 	}
+	if SQL_LIST_COMM_PAGE, err = loadSQL("comments/list_paginated.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_GET_COMM_AUTH, err = loadSQL("comments/get_author.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_UPDATE_COMMENT, err = loadSQL("comments/update.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_CREATE_REPLY, err = loadSQL(insertFile("comments/create_reply_published.sql")); err != nil {
+		panic(err)
+	}
+	if SQL_UNREAD_REPLIES, err = loadSQL("comments/unread_replies.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_REPLIES_SEEN, err = loadSQL("users/mark_replies_seen.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_RECENT_COMMS, err = loadSQL("comments/recent.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_DELETE_COMMS, err = loadSQL("comments/delete_by_post.sql"); err != nil {
+		panic(err)
+	}
 	if SQL_LIKE_EXISTS, err = loadSQL("likes/exists.sql"); err != nil {
 		panic(err)
 	}
@@ -132,6 +575,111 @@ func mustLoadSQL() {
 	if SQL_DELETE_LIKE, err = loadSQL("likes/delete.sql"); err != nil {
 		panic(err)
 	}
+	if SQL_LIKE_STATUS, err = loadSQL("likes/status.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_COUNT_LIKES_BY, err = loadSQL("likes/count_by_user.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_CREATE_LIKE_R, err = loadSQL("likes/create_returning.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_LIKES_RECEIVED, err = loadSQL("likes/received.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_CREATE_FOLLOW, err = loadSQL("follows/create.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_LIST_FOLLOWERS, err = loadSQL("follows/list_followers.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_LIST_FOLLOWING, err = loadSQL("follows/list_following.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_COUNT_POSTS_BY_AUTHOR, err = loadSQL("posts/count_by_author.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_COUNT_FOLLOWERS, err = loadSQL("follows/count_followers.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_COUNT_FOLLOWING, err = loadSQL("follows/count_following.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_COUNT_COMMENTS_BY_POST, err = loadSQL("comments/count_by_post.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_COUNT_LIKES_RECEIVED, err = loadSQL("likes/count_received.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_CREATE_WITH_STATUS, err = loadSQL(insertFile("posts/create_with_status.sql")); err != nil {
+		panic(err)
+	}
+	if SQL_LIST_DRAFTS, err = loadSQL("posts/list_drafts.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_PUBLISH_POST, err = loadSQL("posts/publish.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_GET_PREFS, err = loadSQL("users/get_prefs.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_UPDATE_PREFS, err = loadSQL("users/update_prefs.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_ADMIN_IDS, err = loadSQL("users/admin_ids.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_TAKEN_USERNAMES, err = loadSQL("users/taken_usernames.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_TAKEN_EMAILS, err = loadSQL("users/taken_emails.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_FEED_NEW, err = loadSQL("posts/feed_new.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_RECORD_VIEW, err = loadSQL("posts/record_view.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_GET_POST_AUTHOR_USER, err = loadSQL("users/get_post_author.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_LIST_LIKERS, err = loadSQL("likes/list_likers.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_ENGAGEMENT_SERIES, err = loadSQL("stats/engagement_timeseries.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_COUNT_PUBLISHED, err = loadSQL("posts/count_published.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_COUNT_DRAFTS, err = loadSQL("posts/count_drafts.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_COUNT_USERS, err = loadSQL("users/count.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_ROW_COUNTS, err = loadSQL("stats/row_counts.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_SCHEMA_VERSION, err = loadSQL("stats/schema_version.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_PW_HISTORY, err = loadSQL("auth/password_history.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_PW_HISTORY_PUSH, err = loadSQL("auth/password_history_push.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_LIST_COMM_AUTHOR, err = loadSQL("comments/list_with_author.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_LIST_MUTUALS, err = loadSQL("follows/mutuals.sql"); err != nil {
+		panic(err)
+	}
+	if SQL_COUNT_MUTUALS, err = loadSQL("follows/count_mutuals.sql"); err != nil {
+		panic(err)
+	}
 }
 
 type LoginCredentials struct {
@@ -145,41 +693,290 @@ type CreateUser struct {
 	Password string `json:"password"`
 }
 
+type PasswordChange struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+type ForgotPassword struct {
+	Email string `json:"email"`
+}
+
+type ResetPassword struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
 type UpdateUser struct {
 	Bio *string `json:"bio"`
 }
 
+// maxBioLength caps a bio, in characters, for the bulk bio backfill.
+const maxBioLength = 500
+
+type BioUpdate struct {
+	UserID string  `json:"userId"`
+	Bio    *string `json:"bio"`
+}
+
 type PostCreate struct {
 	Content string `json:"content"`
+	Status  string `json:"status"`
 }
 
 type CommentCreate struct {
-	Content string `json:"content"`
+	Content  string  `json:"content"`
+	ParentID *string `json:"parentId"`
+	TempID   string  `json:"tempId"`
 }
 
-func getTokenFromHeader(c *fiber.Ctx) (string, error) {
-	auth := c.Get("Authorization")
-	if auth == "" {
-		return "", fiber.ErrUnauthorized
-	}
-	parts := strings.SplitN(auth, " ", 2)
-	if len(parts) != 2 {
-		return "", fiber.ErrUnauthorized
-	}
-	return parts[1], nil
+// Connection timeouts that apply once READ_HEADER_TIMEOUT_MS is set: the body gets its
+// own deadline after the headers arrive, and idle keep-alive connections are not held
+// to the header timeout.
+const (
+	bodyReadTimeout      = 60 * time.Second
+	keepAliveIdleTimeout = 2 * time.Minute
+)
+
+// maxLikedStatusIDs caps how many posts a single liked-status lookup may ask about.
+const maxLikedStatusIDs = 100
+
+type LikedStatusRequest struct {
+	PostIDs []string `json:"postIds"`
 }
 
-func decodeToken(tokenStr string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(JWT_SECRET), nil
-	}, jwt.WithValidMethods([]string{"HS256"}))
-	if err != nil || !token.Valid {
-		return nil, fiber.ErrUnauthorized
-	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, fiber.ErrUnauthorized
-	}
+// maxLookupIDs caps how many posts a single lookup may fetch.
+const maxLookupIDs = 100
+
+// maxAvailabilityChecks caps the usernames and the emails one availability check may list.
+const maxAvailabilityChecks = 50
+
+type AvailabilityCheck struct {
+	Usernames []string `json:"usernames"`
+	Emails    []string `json:"emails"`
+}
+
+type PostLookup struct {
+	IDs []string `json:"ids"`
+}
+
+// maxPinnedPosts caps how many posts an author may pin to their profile.
+const maxPinnedPosts = 3
+
+// Random sample bounds: the posts returned per call and the share of the table's
+// pages read by the TABLESAMPLE variant.
+const (
+	maxRandomPosts     = 100
+	tableSamplePercent = 1.0
+)
+
+// Trending hashtag bounds: tags returned and the widest lookback window.
+const (
+	maxTrendingTags   = 50
+	maxTrendingWindow = 30 * 24 * time.Hour
+)
+
+// likeBuckets lists the like-distribution buckets in display order.
+var likeBuckets = []string{"0", "1-9", "10-99", "100+"}
+
+// engagementIntervals whitelists the date_trunc units of the engagement time series,
+// mapped to the longest lookback in days each allows so a series stays a few hundred points.
+var engagementIntervals = map[string]int{"hour": 31, "day": 365, "week": 730}
+
+// maxFeedAuthors caps the author list of a curated feed.
+const maxFeedAuthors = 100
+
+type PostsByAuthors struct {
+	AuthorIDs []string `json:"authorIds"`
+	Limit     *int     `json:"limit"`
+	Offset    int      `json:"offset"`
+}
+
+// maxTreeComments caps how many comments a single comment tree may hold.
+const maxTreeComments = 1000
+
+// maxRecentComments caps the site-wide recent comments ticker.
+const maxRecentComments = 100
+
+// maxLeaderboardSize caps the user leaderboards.
+const maxLeaderboardSize = 100
+
+// userSuggestLimit is the number of usernames returned by the autocomplete endpoint.
+const userSuggestLimit = 10
+
+// likePatternEscaper escapes LIKE wildcards so user input only matches literally.
+var likePatternEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Digest bounds: authors per digest and posts per author.
+const (
+	maxDigestAuthors = 50
+	maxDigestPosts   = 20
+)
+
+// maxBatchSize caps the number of records accepted by the batch creation endpoints.
+const maxBatchSize = 100
+
+type PostTransfer struct {
+	NewAuthorID string `json:"newAuthorId"`
+}
+
+type BatchPostCreate struct {
+	AuthorID string `json:"authorId"`
+	Content  string `json:"content"`
+}
+
+type BatchCommentCreate struct {
+	PostID   string `json:"postId"`
+	AuthorID string `json:"authorId"`
+	Content  string `json:"content"`
+}
+
+// batchResult reports the outcome of one record of a batch write.
+type batchResult struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type FollowPair struct {
+	Follower string `json:"follower"`
+	Followee string `json:"followee"`
+}
+
+// notificationPrefKeys lists the accepted notification_prefs keys; all are on/off switches
+// except digest, which takes one of notificationDigests.
+var notificationPrefKeys = map[string]bool{
+	"likes":    true,
+	"comments": true,
+	"follows":  true,
+	"mentions": true,
+	"digest":   true,
+}
+
+var notificationDigests = []string{"off", "daily", "weekly"}
+
+// parseNotificationPrefs decodes a PUT /auth/me/preferences body, rejecting anything
+// other than a flat object of known keys with values of the right type.
+func parseNotificationPrefs(body []byte) (map[string]any, error) {
+	var prefs map[string]any
+	if err := json.Unmarshal(body, &prefs); err != nil || prefs == nil {
+		return nil, errors.New("preferences must be a JSON object")
+	}
+	for key, value := range prefs {
+		if !notificationPrefKeys[key] {
+			return nil, fmt.Errorf("unknown preference %q", key)
+		}
+		if key == "digest" {
+			if v, ok := value.(string); !ok || !slices.Contains(notificationDigests, v) {
+				return nil, errors.New("digest must be off, daily or weekly")
+			}
+			continue
+		}
+		if _, ok := value.(bool); !ok {
+			return nil, fmt.Errorf("%s must be a boolean", key)
+		}
+	}
+	return prefs, nil
+}
+
+// hashPassword hashes a new password at bcrypt's default cost, raised to MIN_BCRYPT_COST
+// so that login accepts every hash the app writes.
+func hashPassword(pw string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(pw), max(bcrypt.DefaultCost, MIN_BCRYPT_COST))
+}
+
+// checkLogin verifies a password against the stored hash. A matching password is still
+// refused while the account must reset it, or when the hash is below MIN_BCRYPT_COST;
+// flag then reports that the account should be marked must_reset_password. Those checks
+// run only after the password matched, so a stranger can neither flag an account nor
+// learn that its hash is weak.
+func checkLogin(hash, password string, mustReset bool) (flag bool, err error) {
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false, fiber.NewError(http.StatusUnauthorized, "Invalid credentials")
+	}
+	if MIN_BCRYPT_COST > 0 {
+		if cost, err := bcrypt.Cost([]byte(hash)); err != nil || cost < MIN_BCRYPT_COST {
+			return !mustReset, fiber.NewError(http.StatusUnauthorized, "Password reset required")
+		}
+	}
+	if mustReset {
+		return false, fiber.NewError(http.StatusUnauthorized, "Password reset required")
+	}
+	return false, nil
+}
+
+// validatePassword applies PASSWORD_POLICY: "weak" checks the minimum length only,
+// "strong" also requires mixed case, a digit and a symbol. Any other value disables checks.
+func validatePassword(pw string) error {
+	if PASSWORD_POLICY != "weak" && PASSWORD_POLICY != "strong" {
+		return nil
+	}
+	if utf8.RuneCountInString(pw) < PASSWORD_MIN_LENGTH {
+		return fmt.Errorf("Password must be at least %d characters", PASSWORD_MIN_LENGTH)
+	}
+	if PASSWORD_POLICY == "weak" {
+		return nil
+	}
+	var upper, lower, digit, symbol bool
+	for _, r := range pw {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			symbol = true
+		}
+	}
+	switch {
+	case !upper || !lower:
+		return errors.New("Password must mix upper and lower case letters")
+	case !digit:
+		return errors.New("Password must contain a digit")
+	case !symbol:
+		return errors.New("Password must contain a symbol")
+	}
+	return nil
+}
+
+func getTokenFromHeader(c *fiber.Ctx) (string, error) {
+	auth := c.Get("Authorization")
+	if auth == "" {
+		return "", fiber.ErrUnauthorized
+	}
+	parts := strings.SplitN(auth, " ", 2)
+	if len(parts) != 2 {
+		return "", fiber.ErrUnauthorized
+	}
+	return parts[1], nil
+}
+
+// optionalClaims decodes the caller's token when one is sent; anonymous callers get nil.
+func optionalClaims(c *fiber.Ctx) (jwt.MapClaims, error) {
+	if c.Get(fiber.HeaderAuthorization) == "" {
+		return nil, nil
+	}
+	tok, err := getTokenFromHeader(c)
+	if err != nil {
+		return nil, err
+	}
+	return decodeToken(tok)
+}
+
+func decodeToken(tokenStr string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		return []byte(JWT_SECRET), nil
+	}, jwt.WithValidMethods([]string{"HS256"}))
+	if err != nil || !token.Valid {
+		return nil, fiber.ErrUnauthorized
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fiber.ErrUnauthorized
+	}
 	return claims, nil
 }
 
@@ -192,6 +989,286 @@ func requireAdmin(claims jwt.MapClaims) error {
 	return fiber.ErrForbidden
 }
 
+// defaultPageLimit is the page size for routes without a DEFAULT_LIMITS entry.
+const defaultPageLimit = 20
+
+// maxPageLimit caps the page size a client may ask for.
+const maxPageLimit = 100
+
+// parsePagination reads limit/offset, defaulting the limit per route from DEFAULT_LIMITS.
+func parsePagination(c *fiber.Ctx, route string) (int, int, error) {
+	fallback := defaultPageLimit
+	if n, ok := DEFAULT_LIMITS[route]; ok {
+		fallback = n
+	}
+	return checkPage(c.QueryInt("limit", fallback), c.QueryInt("offset", 0))
+}
+
+// checkPage validates a page however it was sent, query string or body, and caps
+// the limit at maxPageLimit. Offsets past MAX_OFFSET are rejected: the database
+// still walks every skipped row.
+func checkPage(limit, offset int) (int, int, error) {
+	if limit < 1 {
+		return 0, 0, fiber.NewError(http.StatusBadRequest, "limit must be a positive integer")
+	}
+	if offset < 0 {
+		return 0, 0, fiber.NewError(http.StatusBadRequest, "offset must not be negative")
+	}
+	if MAX_OFFSET > 0 && offset > MAX_OFFSET {
+		return 0, 0, fiber.NewError(http.StatusBadRequest,
+			fmt.Sprintf("offset must be at most %d; use cursor pagination to read further", MAX_OFFSET))
+	}
+	return min(limit, maxPageLimit), offset, nil
+}
+
+// setRangeHeaders implements RANGE_HEADERS: it totals a list with countSQL and
+// describes the page at offset as X-Total-Count and Content-Range. A negative limit
+// means the page runs to the end of the list. It is a no-op while RANGE_HEADERS is off.
+func setRangeHeaders(c *fiber.Ctx, pool *pgxpool.Pool, offset, limit int, countSQL string, args ...any) error {
+	if !RANGE_HEADERS {
+		return nil
+	}
+	var total int64
+	if err := pool.QueryRow(c.UserContext(), countSQL, args...).Scan(&total); err != nil {
+		if isMissingRefErr(err) {
+			return fiber.NewError(http.StatusNotFound, "Not found")
+		}
+		return fiber.NewError(http.StatusInternalServerError, "Query error")
+	}
+	c.Set("X-Total-Count", strconv.FormatInt(total, 10))
+	end := total
+	if limit >= 0 {
+		end = min(int64(offset)+int64(limit), total)
+	}
+	if int64(offset) >= end {
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("items */%d", total))
+	} else {
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("items %d-%d/%d", offset, end-1, total))
+	}
+	return nil
+}
+
+// checkListETag implements LIST_ETAGS=stats: the weak tag hashes the table fingerprint
+// from statsSQL with the query string, so no rows are read or serialized to answer a
+// matching If-None-Match. It reports true once a 304 has been sent.
+func checkListETag(c *fiber.Ctx, pool *pgxpool.Pool, statsSQL string) (bool, error) {
+	var count, sum int64
+	var latest time.Time
+	if err := pool.QueryRow(c.UserContext(), statsSQL).Scan(&count, &latest, &sum); err != nil {
+		return false, fiber.NewError(http.StatusInternalServerError, "Query error")
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d|%d|%d|%s", count, latest.UnixMicro(), sum, c.Request().URI().QueryString())
+	tag := fmt.Sprintf(`W/"%x"`, h.Sum64())
+	c.Set(fiber.HeaderETag, tag)
+	if etagMatches(c.Get(fiber.HeaderIfNoneMatch), tag) {
+		return true, c.SendStatus(http.StatusNotModified)
+	}
+	return false, nil
+}
+
+// routeName tags a route with the identifier used for its ROUTE_TIMEOUTS entry.
+func routeName(name string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("route", name)
+		return c.Next()
+	}
+}
+
+// queryCtx bounds a handler's queries by its route's ROUTE_TIMEOUTS entry, falling
+// back to DB_QUERY_TIMEOUT_MS. A zero timeout leaves the request context as is.
+func queryCtx(c *fiber.Ctx) (context.Context, context.CancelFunc) {
+	timeout := DB_QUERY_TIMEOUT_MS
+	if route, ok := c.Locals("route").(string); ok {
+		if ms, ok := ROUTE_TIMEOUTS[route]; ok {
+			timeout = ms
+		}
+	}
+	if timeout <= 0 {
+		return c.UserContext(), func() {}
+	}
+	return context.WithTimeout(c.UserContext(), time.Duration(timeout)*time.Millisecond)
+}
+
+// rowQuerier is what pgxpool.Pool and pgx.Tx share for single-row statements, letting a
+// handler run the same writes inside or outside a transaction.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// timedQuery runs a query bounded by ctx. With DB_SERVER_TIMEOUT the remaining time on
+// ctx is also set as a transaction-local statement_timeout, so Postgres aborts the
+// query itself instead of only the client giving up. The transaction ends with rows.
+func timedQuery(ctx context.Context, pool *pgxpool.Pool, sql string, args ...any) (pgx.Rows, error) {
+	deadline, ok := ctx.Deadline()
+	if !DB_SERVER_TIMEOUT || !ok {
+		return pool.Query(ctx, sql, args...)
+	}
+	ms := time.Until(deadline).Milliseconds()
+	if ms <= 0 {
+		return nil, context.DeadlineExceeded
+	}
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, "SELECT set_config('statement_timeout', $1, true)", strconv.FormatInt(ms, 10)); err != nil {
+		tx.Rollback(context.Background())
+		return nil, err
+	}
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		tx.Rollback(context.Background())
+		return nil, err
+	}
+	// Read-only, so rolling back is as good as committing and resets the timeout
+	return rowsWithCancel{rows, func() { tx.Rollback(context.Background()) }}, nil
+}
+
+// timedRow adapts timedQuery to the single-row pgx.Row interface.
+type timedRow struct {
+	rows pgx.Rows
+	err  error
+}
+
+func timedQueryRow(ctx context.Context, pool *pgxpool.Pool, sql string, args ...any) pgx.Row {
+	rows, err := timedQuery(ctx, pool, sql, args...)
+	return timedRow{rows: rows, err: err}
+}
+
+func (r timedRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	defer r.rows.Close()
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return pgx.ErrNoRows
+	}
+	return r.rows.Scan(dest...)
+}
+
+// rowsWithCancel releases a query context once its rows are closed, for rows
+// handed to a body stream that outlives the handler.
+type rowsWithCancel struct {
+	pgx.Rows
+	cancel context.CancelFunc
+}
+
+func (r rowsWithCancel) Close() {
+	r.Rows.Close()
+	r.cancel()
+}
+
+// isMissingRefErr reports whether a write failed because a referenced row does
+// not exist: a foreign key violation or an id that is not a valid UUID.
+func isMissingRefErr(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == "23503" || pgErr.Code == "22P02"
+}
+
+// setRetryAfter advertises, in whole seconds rounded up, when a throttled client may retry.
+// Up to RETRY_AFTER_JITTER_SEC extra seconds are added at random so throttled clients
+// don't all come back at the same instant.
+func setRetryAfter(c *fiber.Ctx, wait time.Duration) {
+	secs := max(int((wait+time.Second-1)/time.Second), 1)
+	if RETRY_AFTER_JITTER_SEC > 0 {
+		secs += rand.IntN(RETRY_AFTER_JITTER_SEC + 1)
+	}
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(secs))
+}
+
+// acquireConn checks out a single pooled connection for batch endpoints, so every
+// statement of a request shares it instead of competing for the pool separately.
+func acquireConn(ctx context.Context, pool *pgxpool.Pool) (*pgxpool.Conn, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, fiber.NewError(http.StatusServiceUnavailable, "Database unavailable")
+	}
+	return conn, nil
+}
+
+// runBatchInsert writes n records in one transaction, insert creating record i and
+// returning its id. By default the first failure rolls everything back and is returned.
+// In best-effort mode each record runs under its own savepoint, so failures are only
+// reported in the per-index results and the valid records are committed.
+func runBatchInsert(ctx context.Context, pool *pgxpool.Pool, n int, bestEffort bool, insert func(context.Context, pgx.Tx, int) (string, error)) ([]batchResult, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, fiber.NewError(http.StatusServiceUnavailable, "Database unavailable")
+	}
+	defer tx.Rollback(ctx)
+
+	results := make([]batchResult, n)
+	for i := 0; i < n; i++ {
+		if !bestEffort {
+			id, err := insert(ctx, tx, i)
+			if err != nil {
+				return nil, fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Record %d: %s", i, batchErrorMessage(err)))
+			}
+			results[i] = batchResult{Index: i, Status: http.StatusCreated, ID: id}
+			continue
+		}
+		sp, err := tx.Begin(ctx)
+		if err != nil {
+			return nil, fiber.NewError(http.StatusInternalServerError, "Savepoint error")
+		}
+		id, err := insert(ctx, sp, i)
+		if err != nil {
+			sp.Rollback(ctx)
+			results[i] = batchResult{Index: i, Status: http.StatusBadRequest, Error: batchErrorMessage(err)}
+			continue
+		}
+		if err := sp.Commit(ctx); err != nil {
+			return nil, fiber.NewError(http.StatusInternalServerError, "Savepoint error")
+		}
+		results[i] = batchResult{Index: i, Status: http.StatusCreated, ID: id}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fiber.NewError(http.StatusInternalServerError, "Commit error")
+	}
+	return results, nil
+}
+
+// batchErrorMessage keeps validation messages but hides raw database errors.
+func batchErrorMessage(err error) string {
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return fe.Message
+	}
+	return "Insert failed"
+}
+
+// requireSelfOrAdmin allows the request when the caller is the given user or an admin.
+func requireSelfOrAdmin(claims jwt.MapClaims, userID string) error {
+	if fmt.Sprint(claims["sub"]) == userID {
+		return nil
+	}
+	return requireAdmin(claims)
+}
+
+// prefersMinimal reports whether the client asked for Prefer: return=minimal.
+func prefersMinimal(c *fiber.Ctx) bool {
+	for _, pref := range strings.Split(c.Get("Prefer"), ",") {
+		if strings.EqualFold(strings.TrimSpace(pref), "return=minimal") {
+			return true
+		}
+	}
+	return false
+}
+
+// createdMinimal answers a creation with 201, a Location header and no body.
+func createdMinimal(c *fiber.Ctx, location string) error {
+	c.Set(fiber.HeaderLocation, location)
+	c.Set("Preference-Applied", "return=minimal")
+	return c.SendStatus(http.StatusCreated)
+}
+
 func shapeUserRow(row pgx.Row) (map[string]any, error) {
 	var id any
 	var username, email string
@@ -200,13 +1277,150 @@ func shapeUserRow(row pgx.Row) (map[string]any, error) {
 	if err := row.Scan(&id, &username, &email, &bio, &createdAt); err != nil {
 		return nil, err
 	}
-	return map[string]any{
+	return userMap(id, username, email, bio, createdAt), nil
+}
+
+// userMap builds the user response shape shared by every user-returning query.
+func userMap(id any, username, email string, bio *string, createdAt time.Time) map[string]any {
+	user := map[string]any{
 		"id":        uuidToString(id),
 		"username":  username,
 		"email":     email,
-		"bio":       bio,
-		"createdAt": createdAt,
-	}, nil
+		"createdAt": formatTime(createdAt),
+	}
+	setNullable(user, "bio", bio)
+	return user
+}
+
+// userViewer decides which private user fields a caller sees: email and isAdmin are
+// shown only to the user themselves and to admins. A nil viewer is an anonymous caller.
+type userViewer struct {
+	id     string
+	admin  bool
+	admins map[string]bool
+}
+
+func newUserViewer(claims jwt.MapClaims) *userViewer {
+	if claims == nil {
+		return nil
+	}
+	return &userViewer{id: fmt.Sprint(claims["sub"]), admin: requireAdmin(claims) == nil}
+}
+
+// loadAdmins fetches the admin ids an admin viewer needs to label other users.
+// Admins are few, so the whole set is cheaper than a lookup per shaped row.
+func (v *userViewer) loadAdmins(ctx context.Context, pool *pgxpool.Pool) error {
+	if v == nil || !v.admin {
+		return nil
+	}
+	rows, err := pool.Query(ctx, SQL_ADMIN_IDS)
+	if err != nil {
+		return fiber.NewError(http.StatusInternalServerError, "Query error")
+	}
+	defer rows.Close()
+	v.admins = make(map[string]bool)
+	for rows.Next() {
+		var id any
+		if err := rows.Scan(&id); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Scan error")
+		}
+		v.admins[uuidToString(id)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fiber.NewError(http.StatusInternalServerError, "Query error")
+	}
+	return nil
+}
+
+// apply drops or fills the private fields of a shaped user for this viewer.
+func (v *userViewer) apply(user map[string]any) map[string]any {
+	id, _ := user["id"].(string)
+	switch {
+	case v == nil:
+		delete(user, "email")
+	case id == v.id:
+		user["isAdmin"] = v.admin
+	case v.admin:
+		user["isAdmin"] = v.admins[id]
+	default:
+		delete(user, "email")
+	}
+	return user
+}
+
+// shaper wraps a user shaper so every row goes through apply.
+func (v *userViewer) shaper(shape func(pgx.Row) (map[string]any, error)) func(pgx.Row) (map[string]any, error) {
+	return func(row pgx.Row) (map[string]any, error) {
+		user, err := shape(row)
+		if err != nil {
+			return nil, err
+		}
+		return v.apply(user), nil
+	}
+}
+
+// formatTime renders a timestamp field per TIMESTAMP_FORMAT: RFC3339 by default,
+// or "unixms" for epoch milliseconds.
+func formatTime(t time.Time) any {
+	if TIMESTAMP_FORMAT == "unixms" {
+		return t.UnixMilli()
+	}
+	return t
+}
+
+// setNullable stores an optional field, leaving it out entirely under OMIT_NULLS.
+func setNullable[T any](m map[string]any, key string, v *T) {
+	if v == nil && OMIT_NULLS {
+		return
+	}
+	m[key] = v
+}
+
+// shapeVersionedUserRow is shapeUserRow for queries that also return the row version.
+func shapeVersionedUserRow(row pgx.Row) (map[string]any, int64, error) {
+	var id any
+	var username, email string
+	var bio *string
+	var createdAt time.Time
+	var version int64
+	if err := row.Scan(&id, &username, &email, &bio, &createdAt, &version); err != nil {
+		return nil, 0, err
+	}
+	return userMap(id, username, email, bio, createdAt), version, nil
+}
+
+// etagMatches reports whether an If-None-Match header lists tag, comparing weakly
+// as RFC 9110 requires for GET.
+func etagMatches(header, tag string) bool {
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// hashResetToken is the form a reset token is stored and looked up in.
+func hashResetToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// parseIfMatchVersion extracts the row version from an If-Match header.
+// It returns nil when the header is absent or "*", meaning no precondition.
+func parseIfMatchVersion(header string) (*int64, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+	header = strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	v, err := strconv.ParseInt(header, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
 }
 
 func shapePostRow(row pgx.Row) (map[string]any, error) {
@@ -222,39 +1436,591 @@ func shapePostRow(row pgx.Row) (map[string]any, error) {
 		"authorId":  uuidToString(authorVal),
 		"content":   content,
 		"likeCount": int(likeCount),
-		"createdAt": createdAt,
+		"createdAt": formatTime(createdAt),
 	}, nil
 }
 
-func shapeCommentRow(row pgx.Row) (map[string]any, error) {
-	var idVal, authorVal, postVal any
-	var content string
+// shapeExportedPostRow is shapePostRow plus the status, so the admin export keeps
+// drafts distinguishable from published posts.
+func shapeExportedPostRow(row pgx.Row) (map[string]any, error) {
+	var idVal, authorVal any
+	var content, status string
 	var createdAt time.Time
-	if err := row.Scan(&idVal, &authorVal, &postVal, &content, &createdAt); err != nil {
+	var likeCount int32
+	if err := row.Scan(&idVal, &authorVal, &content, &createdAt, &likeCount, &status); err != nil {
 		return nil, err
 	}
 	return map[string]any{
 		"id":        uuidToString(idVal),
 		"authorId":  uuidToString(authorVal),
-		"post_id":   uuidToString(postVal),
 		"content":   content,
-		"createdAt": createdAt,
+		"likeCount": int(likeCount),
+		"status":    status,
+		"createdAt": formatTime(createdAt),
 	}, nil
 }
 
-// uuidToString converts various pgx-decoded UUID forms into a canonical string.
-func uuidToString(v any) string {
-	switch t := v.(type) {
-	case string:
-		return t
-	case []byte:
-		if len(t) == 16 {
-			return uuidFrom16Bytes(t)
-		}
-		return string(t)
-	case [16]byte:
-		return uuidFrom16Bytes(t[:])
-	default:
+// postCursor is a SNAPSHOT_PAGINATION position: the newest created_at visible when
+// the scan started, and the (created_at, id) key of the last post served.
+type postCursor struct {
+	asOf, createdAt time.Time
+	id              string
+}
+
+// encode renders the cursor as an opaque URL-safe token.
+func (k postCursor) encode() string {
+	raw := fmt.Sprintf("%d.%d.%s", k.asOf.UnixMicro(), k.createdAt.UnixMicro(), k.id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parsePostCursor decodes a token produced by postCursor.encode.
+func parsePostCursor(token string) (*postCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(string(raw), ".", 3)
+	if len(parts) != 3 {
+		return nil, errors.New("malformed cursor")
+	}
+	asOf, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	createdAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &postCursor{asOf: time.UnixMicro(asOf), createdAt: time.UnixMicro(createdAt), id: parts[2]}, nil
+}
+
+// activityCursor is the (created_at, type, key) position of the last activity item
+// served; key is the item id, or the liked post for likes.
+type activityCursor struct {
+	createdAt time.Time
+	kind, key string
+}
+
+// encode renders the cursor as an opaque URL-safe token.
+func (k activityCursor) encode() string {
+	raw := fmt.Sprintf("%d.%s.%s", k.createdAt.UnixMicro(), k.kind, k.key)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parseActivityCursor decodes a token produced by activityCursor.encode.
+func parseActivityCursor(token string) (*activityCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(string(raw), ".", 3)
+	if len(parts) != 3 {
+		return nil, errors.New("malformed cursor")
+	}
+	createdAt, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	switch parts[1] {
+	case "post", "comment", "like":
+	default:
+		return nil, errors.New("malformed cursor")
+	}
+	var key pgtype.UUID
+	if err := key.Scan(parts[2]); err != nil {
+		return nil, err
+	}
+	return &activityCursor{createdAt: time.UnixMicro(createdAt), kind: parts[1], key: parts[2]}, nil
+}
+
+// keysetPostShaper is shapePostRow that also records the key of each row into last.
+func keysetPostShaper(last *postCursor) func(pgx.Row) (map[string]any, error) {
+	return func(row pgx.Row) (map[string]any, error) {
+		var idVal, authorVal any
+		var content string
+		var createdAt time.Time
+		var likeCount int32
+		if err := row.Scan(&idVal, &authorVal, &content, &createdAt, &likeCount); err != nil {
+			return nil, err
+		}
+		last.createdAt, last.id = createdAt, uuidToString(idVal)
+		return map[string]any{
+			"id":        last.id,
+			"authorId":  uuidToString(authorVal),
+			"content":   content,
+			"likeCount": int(likeCount),
+			"createdAt": formatTime(createdAt),
+		}, nil
+	}
+}
+
+// postSortColumns whitelists the fields GET /posts can be sorted by.
+var postSortColumns = map[string]string{
+	"createdAt": "p.created_at",
+	"likeCount": "p.likes_count",
+	"id":        "p.id",
+}
+
+// parsePostSort turns a spec like "likeCount:desc,createdAt:asc" into an ORDER BY
+// list. Only whitelisted columns and directions are emitted; p.id is appended as a
+// tie-breaker so pages stay stable.
+func parsePostSort(spec string) (string, error) {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(spec, ",") {
+		field, dir, _ := strings.Cut(strings.TrimSpace(part), ":")
+		column, ok := postSortColumns[field]
+		if !ok {
+			return "", fmt.Errorf("Unknown sort field: %s", field)
+		}
+		if seen[field] {
+			return "", fmt.Errorf("Duplicate sort field: %s", field)
+		}
+		seen[field] = true
+		switch strings.ToLower(dir) {
+		case "", "asc":
+			terms = append(terms, column+" ASC")
+		case "desc":
+			terms = append(terms, column+" DESC")
+		default:
+			return "", fmt.Errorf("Invalid sort direction: %s", dir)
+		}
+	}
+	if !seen["id"] {
+		terms = append(terms, "p.id ASC")
+	}
+	return strings.Join(terms, ", "), nil
+}
+
+// postShaper picks the post shaping function for a request. With ?stats=true it
+// adds charCount and wordCount, computed in Go from the content.
+func postShaper(c *fiber.Ctx) func(pgx.Row) (map[string]any, error) {
+	return withPostStats(c, shapePostRow)
+}
+
+// withPostStats wraps a post shaper with the ?stats=true content metrics.
+func withPostStats(c *fiber.Ctx, shape func(pgx.Row) (map[string]any, error)) func(pgx.Row) (map[string]any, error) {
+	if !c.QueryBool("stats") {
+		return shape
+	}
+	return func(row pgx.Row) (map[string]any, error) {
+		post, err := shape(row)
+		if err != nil {
+			return nil, err
+		}
+		content := post["content"].(string)
+		post["charCount"] = utf8.RuneCountInString(content)
+		// strings.Fields splits on unicode.IsSpace, so all Unicode whitespace counts
+		post["wordCount"] = len(strings.Fields(content))
+		return post, nil
+	}
+}
+
+// shapePinnedPostRow is shapePostRow for queries that also return pinned.
+func shapePinnedPostRow(row pgx.Row) (map[string]any, error) {
+	var idVal, authorVal any
+	var content string
+	var createdAt time.Time
+	var likeCount int32
+	var pinned bool
+	if err := row.Scan(&idVal, &authorVal, &content, &createdAt, &likeCount, &pinned); err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"id":        uuidToString(idVal),
+		"authorId":  uuidToString(authorVal),
+		"content":   content,
+		"likeCount": int(likeCount),
+		"createdAt": formatTime(createdAt),
+		"pinned":    pinned,
+	}, nil
+}
+
+// shapeCountedPostRow is shapePostRow for queries that also return comment_count.
+func shapeCountedPostRow(row pgx.Row) (map[string]any, error) {
+	var idVal, authorVal any
+	var content string
+	var createdAt time.Time
+	var likeCount int32
+	var commentCount int64
+	if err := row.Scan(&idVal, &authorVal, &content, &createdAt, &likeCount, &commentCount); err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"id":           uuidToString(idVal),
+		"authorId":     uuidToString(authorVal),
+		"content":      content,
+		"likeCount":    int(likeCount),
+		"commentCount": commentCount,
+		"createdAt":    formatTime(createdAt),
+	}, nil
+}
+
+func shapeCommentRow(row pgx.Row) (map[string]any, error) {
+	var idVal, authorVal, postVal any
+	var content string
+	var createdAt time.Time
+	if err := row.Scan(&idVal, &authorVal, &postVal, &content, &createdAt); err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"id":        uuidToString(idVal),
+		"authorId":  uuidToString(authorVal),
+		"postId":    uuidToString(postVal),
+		"content":   content,
+		"createdAt": formatTime(createdAt),
+	}, nil
+}
+
+// commentAuthorShaper shapes list_with_author.sql rows: the lean comment plus its
+// author, trimmed for the viewer like any other user.
+func commentAuthorShaper(viewer *userViewer) func(pgx.Row) (map[string]any, error) {
+	return func(row pgx.Row) (map[string]any, error) {
+		var idVal, authorVal, postVal any
+		var content, username, email string
+		var bio *string
+		var createdAt, authorCreatedAt time.Time
+		if err := row.Scan(&idVal, &authorVal, &postVal, &content, &createdAt,
+			&username, &email, &bio, &authorCreatedAt); err != nil {
+			return nil, err
+		}
+		return map[string]any{
+			"id":        uuidToString(idVal),
+			"authorId":  uuidToString(authorVal),
+			"postId":    uuidToString(postVal),
+			"content":   content,
+			"createdAt": formatTime(createdAt),
+			"author":    viewer.apply(userMap(authorVal, username, email, bio, authorCreatedAt)),
+		}, nil
+	}
+}
+
+// jsonapiResource splits a shaped row into a JSON:API resource object.
+func jsonapiResource(kind string, m map[string]any) map[string]any {
+	attrs := make(map[string]any, len(m))
+	for k, v := range m {
+		if k != "id" {
+			attrs[k] = v
+		}
+	}
+	return map[string]any{"type": kind, "id": m["id"], "attributes": attrs}
+}
+
+func responseContentType() string {
+	if RESPONSE_FORMAT == "jsonapi" {
+		return "application/vnd.api+json"
+	}
+	return fiber.MIMEApplicationJSON
+}
+
+// snakeKeys caches camelCase field names converted for FIELD_CASE=snake.
+var snakeKeys sync.Map
+
+// snakeCase converts a camelCase field name: "authorId" -> "author_id", "HTTPServer" -> "http_server".
+func snakeCase(key string) string {
+	if cached, ok := snakeKeys.Load(key); ok {
+		return cached.(string)
+	}
+	runes := []rune(key)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]))
+			acronymEnd := i > 0 && unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || acronymEnd {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	snakeKeys.Store(key, b.String())
+	return b.String()
+}
+
+// applyFieldCase renames response fields per FIELD_CASE. Shapers emit camelCase, the
+// canonical form, so only "snake" does any work. Maps are rewritten in place of a copy;
+// anything else (structs, typed slices) is normalized through a JSON round trip.
+func applyFieldCase(v any) any {
+	if FIELD_CASE != "snake" {
+		return v
+	}
+	switch t := v.(type) {
+	case nil, string, bool, int, int32, int64, float64, time.Time, *string:
+		return v
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, inner := range t {
+			out[snakeCase(k)] = applyFieldCase(inner)
+		}
+		return out
+	case fiber.Map:
+		return applyFieldCase(map[string]any(t))
+	case []map[string]any:
+		out := make([]any, len(t))
+		for i, inner := range t {
+			out[i] = applyFieldCase(inner)
+		}
+		return out
+	case []fiber.Map:
+		out := make([]any, len(t))
+		for i, inner := range t {
+			out[i] = applyFieldCase(map[string]any(inner))
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i, inner := range t {
+			out[i] = applyFieldCase(inner)
+		}
+		return out
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var generic any
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return v
+	}
+	if _, ok := generic.(map[string]any); !ok {
+		if _, ok := generic.([]any); !ok {
+			return v
+		}
+	}
+	return applyFieldCase(generic)
+}
+
+// resourceDocument, collectionDocument and metaDocument build the response body for
+// RESPONSE_FORMAT. The flat default returns the shaped data untouched.
+func resourceDocument(kind string, m map[string]any) any {
+	if FIELD_CASE == "snake" {
+		m = applyFieldCase(m).(map[string]any)
+	}
+	if RESPONSE_FORMAT != "jsonapi" {
+		return m
+	}
+	return fiber.Map{"data": jsonapiResource(kind, m)}
+}
+
+func collectionDocument(kind string, list []map[string]any) any {
+	if FIELD_CASE == "snake" {
+		cased := make([]map[string]any, len(list))
+		for i, m := range list {
+			cased[i] = applyFieldCase(m).(map[string]any)
+		}
+		list = cased
+	}
+	if RESPONSE_FORMAT != "jsonapi" {
+		return list
+	}
+	data := make([]map[string]any, len(list))
+	for i, m := range list {
+		data[i] = jsonapiResource(kind, m)
+	}
+	return fiber.Map{"data": data}
+}
+
+// metaDocument wraps payloads that are not resources, such as counts or tokens.
+func metaDocument(v any) any {
+	v = applyFieldCase(v)
+	if RESPONSE_FORMAT != "jsonapi" {
+		return v
+	}
+	return fiber.Map{"meta": v}
+}
+
+// streamItem shapes one element of a streamed list.
+func streamItem(kind string, m map[string]any) any {
+	if FIELD_CASE == "snake" {
+		m = applyFieldCase(m).(map[string]any)
+	}
+	if RESPONSE_FORMAT != "jsonapi" {
+		return m
+	}
+	return jsonapiResource(kind, m)
+}
+
+func sendResource(c *fiber.Ctx, kind string, m map[string]any) error {
+	return c.JSON(resourceDocument(kind, m), responseContentType())
+}
+
+func sendCollection(c *fiber.Ctx, kind string, list []map[string]any) error {
+	return c.JSON(collectionDocument(kind, list), responseContentType())
+}
+
+// sendNoContent ends a bodiless action: 204, or 200 with {} under NO_CONTENT_AS_200
+// for clients that mishandle 204.
+func sendNoContent(c *fiber.Ctx) error {
+	if NO_CONTENT_AS_200 {
+		return c.Status(http.StatusOK).JSON(fiber.Map{})
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+func sendMeta(c *fiber.Ctx, v any) error {
+	return c.JSON(metaDocument(v), responseContentType())
+}
+
+// jsonapiErrorHandler renders errors as a JSON:API errors document.
+func jsonapiErrorHandler(c *fiber.Ctx, err error) error {
+	code := http.StatusInternalServerError
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		code = fe.Code
+	}
+	return c.Status(code).JSON(fiber.Map{
+		"errors": []fiber.Map{{"status": strconv.Itoa(code), "detail": err.Error()}},
+	}, responseContentType())
+}
+
+// streamJSONArray writes rows as a JSON array, encoding each shaped row as it is
+// scanned instead of buffering the whole page. It takes ownership of rows.
+// A mid-stream failure leaves the array unterminated so clients can detect it.
+func streamJSONArray(c *fiber.Ctx, kind string, rows pgx.Rows, shape func(pgx.Row) (map[string]any, error)) error {
+	prefix, suffix := "[", "]"
+	if RESPONSE_FORMAT == "jsonapi" {
+		prefix, suffix = `{"data":[`, "]}"
+	}
+	c.Set(fiber.HeaderContentType, responseContentType())
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer rows.Close()
+		if _, err := w.WriteString(prefix); err != nil {
+			return
+		}
+		n := 0
+		for rows.Next() {
+			item, err := shape(rows)
+			if err != nil {
+				log.Printf("stream scan error: %v", err)
+				return
+			}
+			b, err := json.Marshal(streamItem(kind, item))
+			if err != nil {
+				log.Printf("stream encode error: %v", err)
+				return
+			}
+			if n > 0 {
+				w.WriteByte(',')
+			}
+			if _, err := w.Write(b); err != nil {
+				return
+			}
+			// Flush the first row right away to get bytes on the wire early
+			if n == 0 {
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+			n++
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("stream rows error: %v", err)
+			return
+		}
+		w.WriteString(suffix)
+		w.Flush()
+	})
+	return nil
+}
+
+// shapeReplyRow is shapeCommentRow for queries that also return parent_id.
+func shapeReplyRow(row pgx.Row) (map[string]any, error) {
+	var idVal, authorVal, postVal, parentVal any
+	var content string
+	var createdAt time.Time
+	if err := row.Scan(&idVal, &authorVal, &postVal, &content, &createdAt, &parentVal); err != nil {
+		return nil, err
+	}
+	comment := map[string]any{
+		"id":        uuidToString(idVal),
+		"authorId":  uuidToString(authorVal),
+		"postId":    uuidToString(postVal),
+		"content":   content,
+		"createdAt": formatTime(createdAt),
+	}
+	if parentVal != nil {
+		comment["parentId"] = uuidToString(parentVal)
+	} else {
+		setNullable(comment, "parentId", (*string)(nil))
+	}
+	return comment, nil
+}
+
+// shapeEditedCommentRow is shapeCommentRow for queries that also return updated_at.
+func shapeEditedCommentRow(row pgx.Row) (map[string]any, error) {
+	var idVal, authorVal, postVal any
+	var content string
+	var createdAt time.Time
+	var updatedAt *time.Time
+	if err := row.Scan(&idVal, &authorVal, &postVal, &content, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	comment := map[string]any{
+		"id":        uuidToString(idVal),
+		"authorId":  uuidToString(authorVal),
+		"postId":    uuidToString(postVal),
+		"content":   content,
+		"createdAt": formatTime(createdAt),
+	}
+	if updatedAt != nil {
+		comment["updatedAt"] = formatTime(*updatedAt)
+	} else {
+		setNullable(comment, "updatedAt", updatedAt)
+	}
+	return comment, nil
+}
+
+// streamNDJSON writes rows as newline-delimited JSON, one shaped row per line.
+// It takes ownership of rows; a mid-stream failure simply ends the stream.
+func streamNDJSON(c *fiber.Ctx, kind string, rows pgx.Rows, shape func(pgx.Row) (map[string]any, error)) error {
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer rows.Close()
+		for rows.Next() {
+			item, err := shape(rows)
+			if err != nil {
+				log.Printf("stream scan error: %v", err)
+				return
+			}
+			b, err := json.Marshal(streamItem(kind, item))
+			if err != nil {
+				log.Printf("stream encode error: %v", err)
+				return
+			}
+			w.Write(b)
+			if err := w.WriteByte('\n'); err != nil {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("stream rows error: %v", err)
+			return
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// acceptsNDJSON reports whether the client prefers newline-delimited JSON over the
+// regular array body; */* and plain JSON keep the array.
+func acceptsNDJSON(c *fiber.Ctx) bool {
+	return c.Accepts(fiber.MIMEApplicationJSON, "application/x-ndjson") == "application/x-ndjson"
+}
+
+// uuidToString converts various pgx-decoded UUID forms into a canonical string.
+func uuidToString(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []byte:
+		if len(t) == 16 {
+			return uuidFrom16Bytes(t)
+		}
+		return string(t)
+	case [16]byte:
+		return uuidFrom16Bytes(t[:])
+	default:
 		return fmt.Sprint(v)
 	}
 }
@@ -274,74 +2040,3395 @@ func uuidFrom16Bytes(b []byte) string {
 	return string(dst)
 }
 
-func main() {
-	// Enable CPU profiling if requested
-	if os.Getenv("CPU_PROFILE") != "" {
-		f, err := os.Create(os.Getenv("CPU_PROFILE"))
+// feedCache holds the encoded first page of the global posts feed for a short TTL.
+// Concurrent misses share a single refresh through singleflight.
+type feedCache struct {
+	ttl     time.Duration
+	mu      sync.RWMutex
+	body    []byte
+	expires time.Time
+	group   singleflight.Group
+}
+
+func (fc *feedCache) get(load func() ([]byte, error)) ([]byte, error) {
+	fc.mu.RLock()
+	body, expires := fc.body, fc.expires
+	fc.mu.RUnlock()
+	if body != nil && time.Now().Before(expires) {
+		return body, nil
+	}
+	v, err, _ := fc.group.Do("posts", func() (any, error) {
+		b, err := load()
 		if err != nil {
-			log.Fatalf("could not create CPU profile: %v", err)
-		}
-		defer f.Close()
-		if err := pprof.StartCPUProfile(f); err != nil {
-			log.Fatalf("could not start CPU profile: %v", err)
+			return nil, err
 		}
-		defer pprof.StopCPUProfile()
+		fc.mu.Lock()
+		fc.body = b
+		fc.expires = time.Now().Add(fc.ttl)
+		fc.mu.Unlock()
+		return b, nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return v.([]byte), nil
+}
 
-	if DATABASE_URL == "" || JWT_SECRET == "" {
-		log.Fatal("DATABASE_URL and JWT_SECRET must be set")
-	}
-	mustLoadSQL()
+// postBroker fans newly created posts out to /posts/stream subscribers.
+// Slow subscribers drop events rather than block the publisher.
+type postBroker struct {
+	max  int
+	mu   sync.Mutex
+	subs map[chan []byte]struct{}
+}
 
-	config, err := pgxpool.ParseConfig(DATABASE_URL)
-	if err != nil {
-		log.Fatalf("failed to parse db config: %v", err)
+const sseBufferSize = 16
+
+func newPostBroker(max int) *postBroker {
+	return &postBroker{max: max, subs: make(map[chan []byte]struct{})}
+}
+
+func (b *postBroker) subscribe() (chan []byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.max > 0 && len(b.subs) >= b.max {
+		return nil, false
 	}
-	// Standardized DB pool configuration (can be overridden via environment variables)
+	ch := make(chan []byte, sseBufferSize)
+	b.subs[ch] = struct{}{}
+	return ch, true
+}
+
+func (b *postBroker) unsubscribe(ch chan []byte) {
+	b.mu.Lock()
+	delete(b.subs, ch)
+	b.mu.Unlock()
+}
+
+func (b *postBroker) publish(post map[string]any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.subs) == 0 {
+		return
+	}
+	msg, err := json.Marshal(streamItem("posts", post))
+	if err != nil {
+		log.Printf("sse encode error: %v", err)
+		return
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// commentBuffer collects comment inserts and writes them as one pgx batch every
+// COMMENT_BATCH_MS. Clients already got a 202, so failed inserts are only logged.
+type commentBuffer struct {
+	pool    *pgxpool.Pool
+	mu      sync.Mutex
+	pending []queuedComment
+}
+
+type queuedComment struct {
+	sql  string
+	args []any
+}
+
+func (cb *commentBuffer) add(sql string, args ...any) {
+	cb.mu.Lock()
+	cb.pending = append(cb.pending, queuedComment{sql: sql, args: args})
+	cb.mu.Unlock()
+}
+
+func (cb *commentBuffer) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cb.flush(context.Background())
+	}
+}
+
+func (cb *commentBuffer) flush(ctx context.Context) {
+	cb.mu.Lock()
+	pending := cb.pending
+	cb.pending = nil
+	cb.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	batch := &pgx.Batch{}
+	for _, q := range pending {
+		batch.Queue(q.sql, q.args...)
+	}
+	if err := cb.pool.SendBatch(ctx, batch).Close(); err == nil {
+		return
+	}
+	// The batch shares one implicit transaction, so replay it row by row to keep the good inserts
+	for _, q := range pending {
+		if _, err := cb.pool.Exec(ctx, q.sql, q.args...); err != nil {
+			log.Printf("buffered comment insert error: %v", err)
+		}
+	}
+}
+
+// explainTarget describes a whitelisted query for /admin/explain. sample, when set,
+// picks a representative row id that is bound as the query's first parameter.
+type explainTarget struct {
+	sql    string
+	sample string
+	args   []any
+}
+
+func explainTargets() map[string]explainTarget {
+	return map[string]explainTarget{
+		"list_posts":    {sql: SQL_LIST_POSTS, args: []any{20, 0}},
+		"list_users":    {sql: SQL_LIST_USERS, args: []any{20, 0}},
+		"get_post":      {sql: SQL_GET_POST, sample: "SELECT id::text FROM posts ORDER BY created_at DESC LIMIT 1"},
+		"get_user":      {sql: SQL_GET_USER, sample: "SELECT id::text FROM users ORDER BY created_at DESC LIMIT 1"},
+		"list_comments": {sql: SQL_LIST_COMMENTS, sample: "SELECT post_id::text FROM comments ORDER BY created_at DESC LIMIT 1"},
+		"list_commented": {
+			sql:    SQL_LIST_COMMENTED,
+			sample: "SELECT author_id::text FROM comments ORDER BY created_at DESC LIMIT 1",
+			args:   []any{20, 0},
+		},
+	}
+}
+
+// isSensitiveKey reports whether a JSON field must never be written to logs or captures.
+func isSensitiveKey(key string) bool {
+	k := strings.ToLower(key)
+	return strings.Contains(k, "password") || strings.Contains(k, "token") || strings.Contains(k, "secret")
+}
+
+func redactValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, inner := range t {
+			if isSensitiveKey(k) {
+				t[k] = "[REDACTED]"
+			} else {
+				t[k] = redactValue(inner)
+			}
+		}
+	case []any:
+		for i, inner := range t {
+			t[i] = redactValue(inner)
+		}
+	}
+	return v
+}
+
+var (
+	// col = $N, col <> $N, ... ; the column is what the parameter stands for
+	sqlComparedParam = regexp.MustCompile(`(?i)([a-z_][a-z0-9_]*)\s*(?:=|<>|!=)\s*\$(\d+)`)
+	// INSERT INTO t (cols) VALUES (values); parameters map to columns by position
+	sqlInsertParams = regexp.MustCompile(`(?is)insert\s+into\s+\S+\s*\(([^)]*)\)\s*values\s*\(([^)]*)\)`)
+	sqlParamRef     = regexp.MustCompile(`\$(\d+)`)
+	// Statement text to the 1-based positions of its sensitive parameters
+	sqlSensitiveParams sync.Map
+)
+
+// isSensitiveColumn extends isSensitiveKey to column names; hashes of passwords and
+// reset tokens are as secret as the values themselves.
+func isSensitiveColumn(col string) bool {
+	return isSensitiveKey(col) || strings.Contains(strings.ToLower(col), "hash")
+}
+
+// sensitiveParams finds the parameters of sql bound to sensitive columns, either by
+// comparison or by position in an INSERT column list. Results are cached per statement.
+func sensitiveParams(sql string) map[int]bool {
+	if cached, ok := sqlSensitiveParams.Load(sql); ok {
+		return cached.(map[int]bool)
+	}
+	found := map[int]bool{}
+	for _, m := range sqlComparedParam.FindAllStringSubmatch(sql, -1) {
+		if isSensitiveColumn(m[1]) {
+			n, _ := strconv.Atoi(m[2])
+			found[n] = true
+		}
+	}
+	for _, m := range sqlInsertParams.FindAllStringSubmatch(sql, -1) {
+		cols, values := strings.Split(m[1], ","), strings.Split(m[2], ",")
+		for i, col := range cols {
+			if i >= len(values) || !isSensitiveColumn(strings.TrimSpace(col)) {
+				continue
+			}
+			for _, ref := range sqlParamRef.FindAllStringSubmatch(values[i], -1) {
+				n, _ := strconv.Atoi(ref[1])
+				found[n] = true
+			}
+		}
+	}
+	sqlSensitiveParams.Store(sql, found)
+	return found
+}
+
+// redactSQLArgs returns a copy of a query's arguments that is safe to log. Parameters
+// bound to sensitive columns are masked, and so are raw bytes (token digests) and
+// anything shaped like a bcrypt hash, wherever they are bound.
+func redactSQLArgs(sql string, args []any) []any {
+	sensitive := sensitiveParams(sql)
+	out := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case []byte:
+			out[i] = "[REDACTED]"
+			continue
+		case string:
+			if strings.HasPrefix(v, "$2a$") || strings.HasPrefix(v, "$2b$") || strings.HasPrefix(v, "$2y$") {
+				out[i] = "[REDACTED]"
+				continue
+			}
+		}
+		if sensitive[i+1] {
+			out[i] = "[REDACTED]"
+		} else {
+			out[i] = arg
+		}
+	}
+	return out
+}
+
+// redactJSON returns a copy of a JSON body with sensitive fields masked.
+// Bodies that are not JSON are replaced wholesale since they cannot be inspected.
+func redactJSON(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return json.RawMessage("null")
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		b, _ := json.Marshal(fmt.Sprintf("[non-JSON body, %d bytes]", len(body)))
+		return b
+	}
+	b, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return b
+}
+
+// captureExchange records one sampled request/response pair, to CAPTURE_DIR when set, else the log.
+func captureExchange(c *fiber.Ctx) {
+	record, err := json.Marshal(fiber.Map{
+		"time":     time.Now(),
+		"method":   c.Method(),
+		"path":     c.OriginalURL(),
+		"status":   c.Response().StatusCode(),
+		"request":  redactJSON(c.Body()),
+		"response": redactJSON(c.Response().Body()),
+	})
+	if err != nil {
+		log.Printf("capture encode error: %v", err)
+		return
+	}
+	if CAPTURE_DIR == "" {
+		log.Printf("capture: %s", record)
+		return
+	}
+	name := filepath.Join(CAPTURE_DIR, fmt.Sprintf("%d-%s.json", time.Now().UnixNano(), c.Method()))
+	if err := os.WriteFile(name, record, 0o644); err != nil {
+		log.Printf("capture write error: %v", err)
+	}
+}
+
+// publicConfig describes the effective server tuning for harness introspection.
+// It must never include JWT_SECRET, the database URL or any other credential.
+func publicConfig(pool *pgxpool.Pool) fiber.Map {
+	cfg := pool.Config()
+	return fiber.Map{
+		"instance":     instanceID(),
+		"allowedHosts": ALLOWED_HOSTS,
+		"pool": fiber.Map{
+			"maxConns":             cfg.MaxConns,
+			"minConns":             cfg.MinConns,
+			"maxConnIdleTimeSec":   int(cfg.MaxConnIdleTime.Seconds()),
+			"maxConnLifetimeSec":   int(cfg.MaxConnLifetime.Seconds()),
+			"healthCheckPeriodSec": int(cfg.HealthCheckPeriod.Seconds()),
+			"validateOnAcquire":    DB_VALIDATE_ON_ACQUIRE,
+			"breakerThreshold":     DB_BREAKER_THRESHOLD,
+			"breakerCooldownMs":    DB_BREAKER_COOLDOWN_MS,
+			"sslMode":              DB_SSLMODE,
+			"prewarmQuery":         PREWARM_QUERY,
+		},
+		"jwt": fiber.Map{
+			"algorithm":     "HS256",
+			"expireMinutes": JWT_EXPIRE_MINUTES,
+		},
+		"timeouts": fiber.Map{
+			"requestDeadlineMs":   REQUEST_DEADLINE_MS,
+			"readHeaderTimeoutMs": READ_HEADER_TIMEOUT_MS,
+			"dbQueryTimeoutMs":    DB_QUERY_TIMEOUT_MS,
+			"routeTimeouts":       ROUTE_TIMEOUTS,
+			"dbServerTimeout":     DB_SERVER_TIMEOUT,
+		},
+		"pagination": fiber.Map{
+			"defaultLimit":  defaultPageLimit,
+			"maxLimit":      maxPageLimit,
+			"routeDefaults": DEFAULT_LIMITS,
+			"maxOffset":     MAX_OFFSET,
+			"rangeHeaders":  RANGE_HEADERS,
+		},
+		"features": fiber.Map{
+			"instanceHeader":      ENABLE_INSTANCE_HEADER,
+			"streamLists":         STREAM_LISTS,
+			"postsCacheTtlMs":     POSTS_CACHE_TTL_MS,
+			"loginRetryOnDbError": LOGIN_RETRY_ON_DB_ERROR,
+			"insertThenSelect":    INSERT_THEN_SELECT,
+			"captureSampleRate":   CAPTURE_SAMPLE_RATE,
+			"timestampFormat":     TIMESTAMP_FORMAT,
+			"commentBatchMs":      COMMENT_BATCH_MS,
+			"dedupPosts":          DEDUP_POSTS,
+			"listEtags":           LIST_ETAGS,
+			"responseFormat":      RESPONSE_FORMAT,
+			"omitNulls":           OMIT_NULLS,
+			"strictContentType":   STRICT_CONTENT_TYPE,
+			"tracing":             ENABLE_TRACING,
+			"hideOrphanPosts":     HIDE_ORPHAN_POSTS,
+			"noContentAs200":      NO_CONTENT_AS_200,
+			"snapshotPagination":  SNAPSHOT_PAGINATION,
+			"debugSql":            DEBUG_SQL,
+			"fieldCase":           FIELD_CASE,
+			"singleflight":        ENABLE_SINGLEFLIGHT,
+			"monotonicCreatedAt":  MONOTONIC_CREATED_AT,
+		},
+		"influenceWeights": fiber.Map{
+			"followers": INFLUENCE_WEIGHT_FOLLOWERS,
+			"likes":     INFLUENCE_WEIGHT_LIKES,
+			"posts":     INFLUENCE_WEIGHT_POSTS,
+		},
+		"limits": fiber.Map{
+			"minBcryptCost":         MIN_BCRYPT_COST,
+			"maxLikesPerUser":       MAX_LIKES_PER_USER,
+			"maxFollowing":          MAX_FOLLOWING,
+			"maxCommentsPerPost":    MAX_COMMENTS_PER_POST,
+			"availabilityRateLimit": AVAILABILITY_RATE_LIMIT,
+			"postCooldownMs":        POST_COOLDOWN_MS,
+			"retryAfterJitterSec":   RETRY_AFTER_JITTER_SEC,
+			"sseMaxSubscribers":     SSE_MAX_SUBSCRIBERS,
+			"passwordPolicy":        PASSWORD_POLICY,
+			"passwordMinLen":        PASSWORD_MIN_LENGTH,
+			"passwordResetTtlMin":   PASSWORD_RESET_TTL_MIN,
+			"passwordHistorySize":   PASSWORD_HISTORY_SIZE,
+		},
+	}
+}
+
+// isJSONMediaType accepts application/json and any structured-syntax +json type such as
+// application/vnd.api+json, ignoring parameters like charset.
+func isJSONMediaType(header string) bool {
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false
+	}
+	if mediaType == "application/json" {
+		return true
+	}
+	typ, subtype, ok := strings.Cut(mediaType, "/")
+	return ok && typ != "" && strings.HasSuffix(subtype, "+json") && len(subtype) > len("+json")
+}
+
+// applySSLMode overrides the sslmode parsed from DATABASE_URL. pgconn turns sslmode into
+// per-host TLS attempts at parse time, so those are rebuilt here for every host; CA and
+// client certificate settings from the URL do not carry over.
+func applySSLMode(cfg *pgconn.Config, mode string) error {
+	type hostPort struct {
+		host string
+		port uint16
+	}
+	hosts := []hostPort{{cfg.Host, cfg.Port}}
+	seen := map[hostPort]bool{hosts[0]: true}
+	for _, fb := range cfg.Fallbacks {
+		hp := hostPort{fb.Host, fb.Port}
+		if !seen[hp] {
+			seen[hp] = true
+			hosts = append(hosts, hp)
+		}
+	}
+	var attempts []*pgconn.FallbackConfig
+	for _, hp := range hosts {
+		var tlsConfigs []*tls.Config
+		switch mode {
+		case "disable":
+			tlsConfigs = []*tls.Config{nil}
+		case "prefer":
+			tlsConfigs = []*tls.Config{{InsecureSkipVerify: true}, nil}
+		case "require":
+			tlsConfigs = []*tls.Config{{InsecureSkipVerify: true}}
+		case "verify-full":
+			tlsConfigs = []*tls.Config{{ServerName: hp.host}}
+		default:
+			return fmt.Errorf("unsupported DB_SSLMODE %q", mode)
+		}
+		// Unix domain sockets never use TLS
+		if strings.HasPrefix(hp.host, "/") {
+			tlsConfigs = []*tls.Config{nil}
+		}
+		for _, tc := range tlsConfigs {
+			attempts = append(attempts, &pgconn.FallbackConfig{Host: hp.host, Port: hp.port, TLSConfig: tc})
+		}
+	}
+	cfg.Host, cfg.Port, cfg.TLSConfig = attempts[0].Host, attempts[0].Port, attempts[0].TLSConfig
+	cfg.Fallbacks = attempts[1:]
+	return nil
+}
+
+// influenceScore combines a user's reach metrics as a weighted sum of log1p terms,
+// so one viral post cannot dwarf a steady following.
+func influenceScore(followers, likes, posts int64) float64 {
+	return INFLUENCE_WEIGHT_FOLLOWERS*math.Log1p(float64(followers)) +
+		INFLUENCE_WEIGHT_LIKES*math.Log1p(float64(likes)) +
+		INFLUENCE_WEIGHT_POSTS*math.Log1p(float64(posts))
+}
+
+// setupTracing installs an OTLP/HTTP trace exporter, configured through the standard
+// OTEL_EXPORTER_OTLP_* variables. The returned func flushes pending spans.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "go-fiber"))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tp.Shutdown, nil
+}
+
+// queryTracer is a pgx.QueryTracer that records each query as a child span of the
+// request span carried by the query context.
+type queryTracer struct {
+	tracer trace.Tracer
+}
+
+func (t queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = t.tracer.Start(ctx, "db.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", data.SQL),
+		))
+	return ctx
+}
+
+func (t queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+	span.End()
+}
+
+// prewarmPool implements PREWARM_QUERY: it opens n connections at once and runs the
+// hot list queries on each, so statement and plan caches are filled before traffic.
+// Holding every connection until all are warm keeps the pool from handing one out twice.
+func prewarmPool(ctx context.Context, pool *pgxpool.Pool, n int) {
+	start := time.Now()
+	conns := make([]*pgxpool.Conn, n)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := pool.Acquire(ctx)
+			if err != nil {
+				log.Printf("prewarm: acquire failed: %v", err)
+				return
+			}
+			conns[i] = conn
+		}()
+	}
+	wg.Wait()
+	opened := time.Since(start)
+
+	queries := []string{SQL_LIST_POSTS, SQL_LIST_USERS}
+	acquired := 0
+	for _, conn := range conns {
+		if conn == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, sql := range queries {
+				rows, err := conn.Query(ctx, sql, defaultPageLimit, 0)
+				if err != nil {
+					log.Printf("prewarm: %s failed: %v", queryLabel(sql), err)
+					return
+				}
+				rows.Close()
+			}
+		}()
+		acquired++
+	}
+	wg.Wait()
+	for _, conn := range conns {
+		if conn != nil {
+			conn.Release()
+		}
+	}
+	log.Printf("prewarm: %d/%d connections opened in %s, queries warmed in %s",
+		acquired, n, opened.Round(time.Millisecond), (time.Since(start) - opened).Round(time.Millisecond))
+}
+
+// debugSQLTracer implements DEBUG_SQL: it logs every statement, single or batched, with
+// its arguments passed through redactSQLArgs, its duration and its error.
+type debugSQLTracer struct{}
+
+type debugSQLStartKey struct{}
+
+type debugSQLStart struct {
+	sql   string
+	args  []any
+	start time.Time
+}
+
+// queryLabel names a statement by its SQL file, falling back to its first line.
+func queryLabel(sql string) string {
+	if name, ok := sqlFileNames[sql]; ok {
+		return name
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(sql), "\n")
+	const maxLabel = 80
+	if len(line) > maxLabel {
+		line = line[:maxLabel] + "..."
+	}
+	return line
+}
+
+func logSQL(sql string, args []any, elapsed time.Duration, err error) {
+	b, encErr := json.Marshal(redactSQLArgs(sql, args))
+	if encErr != nil {
+		b = []byte(fmt.Sprintf("[%d args]", len(args)))
+	}
+	if err != nil {
+		log.Printf("sql %s args=%s took=%s err=%v", queryLabel(sql), b, elapsed, err)
+		return
+	}
+	log.Printf("sql %s args=%s took=%s", queryLabel(sql), b, elapsed)
+}
+
+func (debugSQLTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, debugSQLStartKey{}, debugSQLStart{sql: data.SQL, args: data.Args, start: time.Now()})
+}
+
+func (debugSQLTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	if q, ok := ctx.Value(debugSQLStartKey{}).(debugSQLStart); ok {
+		logSQL(q.sql, q.args, time.Since(q.start), data.Err)
+	}
+}
+
+func (debugSQLTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceBatchStartData) context.Context {
+	return context.WithValue(ctx, debugSQLStartKey{}, debugSQLStart{start: time.Now()})
+}
+
+// Batched statements are sent together, so each logs the time since the batch started.
+func (debugSQLTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	if q, ok := ctx.Value(debugSQLStartKey{}).(debugSQLStart); ok {
+		logSQL(data.SQL, data.Args, time.Since(q.start), data.Err)
+	}
+}
+
+func (debugSQLTracer) TraceBatchEnd(context.Context, *pgx.Conn, pgx.TraceBatchEndData) {}
+
+// dbBreaker trips after DB_BREAKER_THRESHOLD consecutive database failures. While open,
+// reads fail fast; once the cooldown has passed a single request is let through to
+// probe the database, and the next successful query closes the breaker again. It sees
+// query outcomes as a pgx tracer, so handlers need no changes to feed it.
+type dbBreaker struct {
+	threshold int32
+	cooldown  time.Duration
+	failures  atomic.Int32
+	openedAt  atomic.Int64 // unix nanos, 0 while closed
+}
+
+// allow reports whether a request may reach the database and, if not, how long to wait.
+func (b *dbBreaker) allow() (bool, time.Duration) {
+	opened := b.openedAt.Load()
+	if opened == 0 {
+		return true, 0
+	}
+	elapsed := time.Duration(time.Now().UnixNano() - opened)
+	if elapsed < b.cooldown {
+		return false, b.cooldown - elapsed
+	}
+	// Half-open: re-arming the timer admits exactly one probe per cooldown
+	if b.openedAt.CompareAndSwap(opened, time.Now().UnixNano()) {
+		return true, 0
+	}
+	return false, b.cooldown
+}
+
+func (b *dbBreaker) record(err error) {
+	var pgErr *pgconn.PgError
+	if err == nil || errors.As(err, &pgErr) {
+		// The server answered, so it is reachable
+		b.failures.Store(0)
+		b.openedAt.Store(0)
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if b.failures.Add(1) >= b.threshold {
+		b.openedAt.CompareAndSwap(0, time.Now().UnixNano())
+	}
+}
+
+func (b *dbBreaker) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return ctx
+}
+
+func (b *dbBreaker) TraceQueryEnd(_ context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	b.record(data.Err)
+}
+
+func (b *dbBreaker) TraceAcquireStart(ctx context.Context, _ *pgxpool.Pool, _ pgxpool.TraceAcquireStartData) context.Context {
+	return ctx
+}
+
+// TraceAcquireEnd only counts failures: a pooled connection says nothing about the server.
+func (b *dbBreaker) TraceAcquireEnd(_ context.Context, _ *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	if data.Err != nil {
+		b.record(data.Err)
+	}
+}
+
+// hostAllowed matches a Host header against ALLOWED_HOSTS, with or without its port.
+func hostAllowed(allowed map[string]bool, host string) bool {
+	host = strings.ToLower(host)
+	if allowed[host] {
+		return true
+	}
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return allowed[name]
+	}
+	return false
+}
+
+// newApp creates the Fiber app with the server-level settings: error rendering and
+// the slow-header timeouts. Routes and middleware are added by main.
+func newApp() *fiber.App {
+	appConfig := fiber.Config{DisableStartupMessage: true}
+	if RESPONSE_FORMAT == "jsonapi" {
+		appConfig.ErrorHandler = jsonapiErrorHandler
+	}
+	if READ_HEADER_TIMEOUT_MS > 0 {
+		// fasthttp has no header-only timeout: ReadTimeout bounds the header read and
+		// HeaderReceived re-arms the deadline for the body
+		appConfig.ReadTimeout = time.Duration(READ_HEADER_TIMEOUT_MS) * time.Millisecond
+		appConfig.IdleTimeout = keepAliveIdleTimeout
+	}
+	app := fiber.New(appConfig)
+	if READ_HEADER_TIMEOUT_MS > 0 {
+		app.Server().HeaderReceived = func(*fasthttp.RequestHeader) fasthttp.RequestConfig {
+			return fasthttp.RequestConfig{ReadTimeout: bodyReadTimeout}
+		}
+	}
+	return app
+}
+
+func main() {
+	// Enable CPU profiling if requested
+	if os.Getenv("CPU_PROFILE") != "" {
+		f, err := os.Create(os.Getenv("CPU_PROFILE"))
+		if err != nil {
+			log.Fatalf("could not create CPU profile: %v", err)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatalf("could not start CPU profile: %v", err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	if DATABASE_URL == "" || JWT_SECRET == "" {
+		log.Fatal("DATABASE_URL and JWT_SECRET must be set")
+	}
+	mustLoadSQL()
+
+	config, err := pgxpool.ParseConfig(DATABASE_URL)
+	if err != nil {
+		log.Fatalf("failed to parse db config: %v", err)
+	}
+	if DB_SSLMODE != "" {
+		if err := applySSLMode(&config.ConnConfig.Config, DB_SSLMODE); err != nil {
+			log.Fatalf("failed to apply DB_SSLMODE: %v", err)
+		}
+	}
+	// Standardized DB pool configuration (can be overridden via environment variables)
 	config.MaxConns = int32(getenvInt("DB_POOL_MAX", 50))
 	config.MinConns = int32(getenvInt("DB_POOL_MIN", 10))
 	config.MaxConnIdleTime = time.Duration(getenvInt("DB_POOL_IDLE_TIMEOUT", 300)) * time.Second
 	config.MaxConnLifetime = time.Duration(getenvInt("DB_POOL_MAX_LIFETIME", 1800)) * time.Second
+	config.HealthCheckPeriod = time.Duration(getenvInt("DB_HEALTH_CHECK_PERIOD", 60)) * time.Second
+	var tracers []pgx.QueryTracer
+	var shutdownTracing func(context.Context) error
+	if ENABLE_TRACING {
+		shutdownTracing, err = setupTracing(context.Background())
+		if err != nil {
+			log.Fatalf("failed to set up tracing: %v", err)
+		}
+		tracers = append(tracers, queryTracer{tracer: otel.Tracer("go-fiber/pgx")})
+	}
+	if DEBUG_SQL {
+		log.Println("DEBUG_SQL is on: every query is logged with its arguments; never enable in production")
+		tracers = append(tracers, debugSQLTracer{})
+	}
+	var breaker *dbBreaker
+	if DB_BREAKER_THRESHOLD > 0 {
+		breaker = &dbBreaker{
+			threshold: int32(DB_BREAKER_THRESHOLD),
+			cooldown:  time.Duration(DB_BREAKER_COOLDOWN_MS) * time.Millisecond,
+		}
+		tracers = append(tracers, breaker)
+	}
+	switch len(tracers) {
+	case 0:
+	case 1:
+		config.ConnConfig.Tracer = tracers[0]
+	default:
+		config.ConnConfig.Tracer = multitracer.New(tracers...)
+	}
+	if DB_VALIDATE_ON_ACQUIRE {
+		// Ping before handing out a connection; a dead one is destroyed and the acquire retried
+		config.PrepareConn = func(ctx context.Context, conn *pgx.Conn) (bool, error) {
+			return conn.Ping(ctx) == nil, nil
+		}
+	}
 
 	pool, err := pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
 		log.Fatalf("failed to create db pool: %v", err)
 	}
 	defer pool.Close()
+	if PREWARM_QUERY {
+		prewarmPool(context.Background(), pool, max(int(config.MinConns), 1))
+	}
+
+	app := newApp()
+
+	// One server span per request; handlers pass it on to pgx through c.UserContext()
+	if ENABLE_TRACING {
+		tracer := otel.Tracer("go-fiber/http")
+		propagator := otel.GetTextMapPropagator()
+		app.Use(func(c *fiber.Ctx) error {
+			parent := propagator.Extract(c.UserContext(), propagation.HeaderCarrier(c.GetReqHeaders()))
+			ctx, span := tracer.Start(parent, c.Method(), trace.WithSpanKind(trace.SpanKindServer))
+			defer span.End()
+			c.SetUserContext(ctx)
+			err := c.Next()
+			span.SetName(c.Method() + " " + c.Route().Path)
+			status := c.Response().StatusCode()
+			if err != nil {
+				var fe *fiber.Error
+				if errors.As(err, &fe) {
+					status = fe.Code
+				} else {
+					status = http.StatusInternalServerError
+				}
+				span.RecordError(err)
+			}
+			span.SetAttributes(
+				attribute.String("http.request.method", c.Method()),
+				attribute.String("http.route", c.Route().Path),
+				attribute.Int("http.response.status_code", status),
+			)
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+			return err
+		})
+	}
+
+	if len(ALLOWED_HOSTS) > 0 {
+		allowed := make(map[string]bool, len(ALLOWED_HOSTS))
+		for _, h := range ALLOWED_HOSTS {
+			allowed[strings.ToLower(h)] = true
+		}
+		app.Use(func(c *fiber.Ctx) error {
+			if !hostAllowed(allowed, string(c.Request().Host())) {
+				return fiber.NewError(http.StatusBadRequest, "Invalid Host header")
+			}
+			return c.Next()
+		})
+	}
+
+	// Requests that carry a body must declare it as JSON
+	if STRICT_CONTENT_TYPE {
+		app.Use(func(c *fiber.Ctx) error {
+			if len(c.Body()) > 0 && !isJSONMediaType(c.Get(fiber.HeaderContentType)) {
+				return fiber.NewError(http.StatusUnsupportedMediaType, "Content-Type must be JSON")
+			}
+			return c.Next()
+		})
+	}
+
+	if CAPTURE_SAMPLE_RATE > 0 {
+		app.Use(func(c *fiber.Ctx) error {
+			if rand.Float64() >= CAPTURE_SAMPLE_RATE {
+				return c.Next()
+			}
+			// Render errors here so the captured response is the one the client sees
+			if err := c.Next(); err != nil {
+				if err := c.App().Config().ErrorHandler(c, err); err != nil {
+					return err
+				}
+			}
+			if !c.Response().IsBodyStream() {
+				captureExchange(c)
+			}
+			return nil
+		})
+	}
+
+	// Overall request deadline; handlers pick it up through c.UserContext()
+	if REQUEST_DEADLINE_MS > 0 {
+		deadline := time.Duration(REQUEST_DEADLINE_MS) * time.Millisecond
+		app.Use(func(c *fiber.Ctx) error {
+			ctx, cancel := context.WithTimeout(c.UserContext(), deadline)
+			c.SetUserContext(ctx)
+			err := c.Next()
+			if c.Response().IsBodyStream() {
+				// Stream writers run after the handler returns, keep the deadline armed for them
+				// until it would have fired anyway
+				end, _ := ctx.Deadline()
+				time.AfterFunc(time.Until(end), cancel)
+			} else {
+				cancel()
+			}
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fiber.NewError(http.StatusServiceUnavailable, "Request deadline exceeded")
+			}
+			return err
+		})
+	}
+
+	if breaker != nil {
+		app.Use(func(c *fiber.Ctx) error {
+			if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead {
+				return c.Next()
+			}
+			if ok, wait := breaker.allow(); !ok {
+				setRetryAfter(c, wait)
+				return fiber.NewError(http.StatusServiceUnavailable, "Database unavailable")
+			}
+			return c.Next()
+		})
+	}
+
+	if ENABLE_INSTANCE_HEADER {
+		servedBy := instanceID()
+		app.Use(func(c *fiber.Ctx) error {
+			c.Set("X-Served-By", servedBy)
+			return c.Next()
+		})
+	}
 
-	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	// LIST_ETAGS=body hashes the rendered page; streamed lists have no buffered body to hash
+	listETag := func(c *fiber.Ctx) error { return c.Next() }
+	if LIST_ETAGS == "body" {
+		listETag = etag.New(etag.Config{
+			Weak: true,
+			Next: func(c *fiber.Ctx) bool { return STREAM_LISTS || acceptsNDJSON(c) },
+		})
+	}
 
 	app.Post("/auth/login", func(c *fiber.Ctx) error {
 		var body LoginCredentials
 		if err := c.BodyParser(&body); err != nil {
 			return fiber.NewError(http.StatusBadRequest, "Invalid body")
 		}
-		ctx := c.Context()
-		// Cast id to text to ensure we always get a UUID string
-		row := pool.QueryRow(ctx, "SELECT id::text, password_hash, is_admin FROM users WHERE email = $1", body.Email)
+		ctx := c.UserContext()
 		var idStr string
 		var passwordHash string
-		var isAdmin bool
-		if err := row.Scan(&idStr, &passwordHash, &isAdmin); err != nil {
-			return fiber.NewError(http.StatusUnauthorized, "Invalid credentials")
+		var isAdmin, mustReset bool
+		lookup := func() error {
+			// Cast id to text to ensure we always get a UUID string
+			row := pool.QueryRow(ctx, "SELECT id::text, password_hash, is_admin, must_reset_password FROM users WHERE email = $1", body.Email)
+			return row.Scan(&idStr, &passwordHash, &isAdmin, &mustReset)
+		}
+		err := lookup()
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) && LOGIN_RETRY_ON_DB_ERROR {
+			// One retry absorbs transient blips such as a dead pooled connection
+			err = lookup()
 		}
-		if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(body.Password)) != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
 			return fiber.NewError(http.StatusUnauthorized, "Invalid credentials")
 		}
-		claims := jwt.MapClaims{
-			"sub":      idStr,
-			"is_admin": isAdmin,
-			"exp":      time.Now().Add(time.Duration(JWT_EXPIRE_MINUTES) * time.Minute).Unix(),
+		if err != nil {
+			return fiber.NewError(http.StatusServiceUnavailable, "Service unavailable")
+		}
+		if flag, err := checkLogin(passwordHash, body.Password, mustReset); err != nil {
+			if flag {
+				if _, err := pool.Exec(ctx, SQL_FLAG_RESET, idStr); err != nil {
+					log.Printf("failed to flag password reset for %s: %v", idStr, err)
+				}
+			}
+			return err
+		}
+		claims := jwt.MapClaims{
+			"sub":      idStr,
+			"is_admin": isAdmin,
+			"exp":      time.Now().Add(time.Duration(JWT_EXPIRE_MINUTES) * time.Minute).Unix(),
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		signed, err := token.SignedString([]byte(JWT_SECRET))
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Token error")
+		}
+		return sendMeta(c, fiber.Map{"accessToken": signed})
+	})
+
+	app.Get("/auth/me", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		ctx := c.UserContext()
+		id := fmt.Sprint(claims["sub"])
+		row := pool.QueryRow(ctx, SQL_ME_VERSIONED, id)
+		user, version, err := shapeVersionedUserRow(row)
+		if err != nil {
+			return fiber.NewError(http.StatusUnauthorized, "Unauthorized")
+		}
+		// Every profile write bumps the version, so it doubles as the ETag
+		etag := `"` + strconv.FormatInt(version, 10) + `"`
+		c.Set(fiber.HeaderETag, etag)
+		if etagMatches(c.Get(fiber.HeaderIfNoneMatch), etag) {
+			return c.SendStatus(http.StatusNotModified)
+		}
+		return sendResource(c, "users", newUserViewer(claims).apply(user))
+	})
+
+	app.Get("/auth/me/drafts", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		limit, offset, err := parsePagination(c, "drafts")
+		if err != nil {
+			return err
+		}
+		if err := setRangeHeaders(c, pool, offset, limit, SQL_COUNT_DRAFTS, fmt.Sprint(claims["sub"])); err != nil {
+			return err
+		}
+		ctx := c.UserContext()
+		rows, err := pool.Query(ctx, SQL_LIST_DRAFTS, fmt.Sprint(claims["sub"]), limit, offset)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		defer rows.Close()
+		list := make([]map[string]any, 0)
+		for rows.Next() {
+			post, err := shapePostRow(rows)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			post["status"] = "draft"
+			list = append(list, post)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		return sendCollection(c, "posts", list)
+	})
+
+	app.Get("/auth/me/preferences", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		var prefs map[string]any
+		if err := pool.QueryRow(c.UserContext(), SQL_GET_PREFS, fmt.Sprint(claims["sub"])).Scan(&prefs); err != nil {
+			return fiber.NewError(http.StatusUnauthorized, "Unauthorized")
+		}
+		return sendMeta(c, prefs)
+	})
+
+	app.Put("/auth/me/preferences", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		prefs, err := parseNotificationPrefs(c.Body())
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, err.Error())
+		}
+		var saved map[string]any
+		if err := pool.QueryRow(c.UserContext(), SQL_UPDATE_PREFS, fmt.Sprint(claims["sub"]), prefs).Scan(&saved); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fiber.NewError(http.StatusUnauthorized, "Unauthorized")
+			}
+			return fiber.NewError(http.StatusInternalServerError, "Failed to update preferences")
+		}
+		return sendMeta(c, saved)
+	})
+
+	app.Put("/auth/me/password", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+
+		var body PasswordChange
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		if err := validatePassword(body.NewPassword); err != nil {
+			return fiber.NewError(http.StatusBadRequest, err.Error())
+		}
+		userID := fmt.Sprint(claims["sub"])
+		ctx := c.UserContext()
+		var currentHash string
+		if err := pool.QueryRow(ctx, SQL_GET_PW_HASH, userID).Scan(&currentHash); err != nil {
+			return fiber.NewError(http.StatusUnauthorized, "Unauthorized")
+		}
+		if bcrypt.CompareHashAndPassword([]byte(currentHash), []byte(body.CurrentPassword)) != nil {
+			return fiber.NewError(http.StatusUnauthorized, "Invalid credentials")
+		}
+		if PASSWORD_HISTORY_SIZE <= 0 {
+			hash, err := hashPassword(body.NewPassword)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Hash error")
+			}
+			if _, err := pool.Exec(ctx, SQL_UPDATE_PW, userID, string(hash)); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Failed to update password")
+			}
+			return sendNoContent(c)
+		}
+
+		// The current password is one of the last N, so N-1 older hashes are stored
+		stored := PASSWORD_HISTORY_SIZE - 1
+		previous := []string{currentHash}
+		if stored > 0 {
+			rows, err := pool.Query(ctx, SQL_PW_HISTORY, userID, stored)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Query error")
+			}
+			for rows.Next() {
+				var h string
+				if err := rows.Scan(&h); err != nil {
+					rows.Close()
+					return fiber.NewError(http.StatusInternalServerError, "Scan error")
+				}
+				previous = append(previous, h)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Query error")
+			}
+		}
+		for _, h := range previous {
+			if bcrypt.CompareHashAndPassword([]byte(h), []byte(body.NewPassword)) == nil {
+				return fiber.NewError(http.StatusUnprocessableEntity, fmt.Sprintf("Password was used recently; choose one not among your last %d", PASSWORD_HISTORY_SIZE))
+			}
+		}
+
+		hash, err := hashPassword(body.NewPassword)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Hash error")
+		}
+		tx, err := pool.Begin(ctx)
+		if err != nil {
+			return fiber.NewError(http.StatusServiceUnavailable, "Database unavailable")
+		}
+		defer tx.Rollback(ctx)
+		if _, err := tx.Exec(ctx, SQL_UPDATE_PW, userID, string(hash)); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Failed to update password")
+		}
+		if stored > 0 {
+			if _, err := tx.Exec(ctx, SQL_PW_HISTORY_PUSH, userID, currentHash, stored); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Failed to record password history")
+			}
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Commit error")
+		}
+		return sendNoContent(c)
+	})
+
+	availabilityLimit := func(c *fiber.Ctx) error { return c.Next() }
+	if AVAILABILITY_RATE_LIMIT > 0 {
+		availabilityLimit = limiter.New(limiter.Config{
+			Max:        AVAILABILITY_RATE_LIMIT,
+			Expiration: time.Minute,
+			LimitReached: func(c *fiber.Ctx) error {
+				// The limiter has already set Retry-After to the seconds left in the window;
+				// route it through setRetryAfter so it gets the same jitter as the other 429s
+				wait := time.Minute
+				if secs, err := strconv.Atoi(c.GetRespHeader(fiber.HeaderRetryAfter)); err == nil {
+					wait = time.Duration(secs) * time.Second
+				}
+				setRetryAfter(c, wait)
+				return fiber.NewError(http.StatusTooManyRequests, "Too many requests")
+			},
+		})
+	}
+
+	app.Post("/auth/check-availability", availabilityLimit, func(c *fiber.Ctx) error {
+		var body AvailabilityCheck
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		if len(body.Usernames) > maxAvailabilityChecks || len(body.Emails) > maxAvailabilityChecks {
+			return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("At most %d usernames and %d emails allowed", maxAvailabilityChecks, maxAvailabilityChecks))
+		}
+		ctx := c.UserContext()
+		batch := &pgx.Batch{}
+		batch.Queue(SQL_TAKEN_USERNAMES, body.Usernames)
+		batch.Queue(SQL_TAKEN_EMAILS, body.Emails)
+		conn, err := acquireConn(ctx, pool)
+		if err != nil {
+			return err
+		}
+		defer conn.Release()
+		results := conn.SendBatch(ctx, batch)
+		defer results.Close()
+
+		taken := make([][]string, 2)
+		for i := range taken {
+			rows, err := results.Query()
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Query error")
+			}
+			taken[i] = make([]string, 0)
+			for rows.Next() {
+				var v string
+				if err := rows.Scan(&v); err != nil {
+					rows.Close()
+					return fiber.NewError(http.StatusInternalServerError, "Scan error")
+				}
+				taken[i] = append(taken[i], v)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Query error")
+			}
+		}
+		return sendMeta(c, fiber.Map{"takenUsernames": taken[0], "takenEmails": taken[1]})
+	})
+
+	app.Post("/auth/forgot-password", func(c *fiber.Ctx) error {
+		var body ForgotPassword
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		token := crand.Text()
+		ctx := c.UserContext()
+		var userID any
+		err := pool.QueryRow(ctx, SQL_RESET_CREATE, body.Email, hashResetToken(token), PASSWORD_RESET_TTL_MIN).Scan(&userID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fiber.NewError(http.StatusInternalServerError, "Failed to create reset token")
+		}
+		// Same answer whether or not the email exists; delivering the token is out of scope
+		return sendMeta(c, fiber.Map{"status": "ok"})
+	})
+
+	app.Post("/auth/reset-password", func(c *fiber.Ctx) error {
+		var body ResetPassword
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		if err := validatePassword(body.NewPassword); err != nil {
+			return fiber.NewError(http.StatusBadRequest, err.Error())
+		}
+		hash, err := hashPassword(body.NewPassword)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Hash error")
+		}
+		ctx := c.UserContext()
+		tx, err := pool.Begin(ctx)
+		if err != nil {
+			return fiber.NewError(http.StatusServiceUnavailable, "Database unavailable")
+		}
+		defer tx.Rollback(ctx)
+		var userID any
+		if err := tx.QueryRow(ctx, SQL_RESET_CONSUME, hashResetToken(body.Token)).Scan(&userID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fiber.NewError(http.StatusBadRequest, "Invalid or expired token")
+			}
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		if _, err := tx.Exec(ctx, SQL_UPDATE_PW, userID, string(hash)); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Failed to update password")
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Commit error")
+		}
+		return sendNoContent(c)
+	})
+
+	app.Get("/auth/me/replies", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+
+		limit, offset, err := parsePagination(c, "replies")
+		if err != nil {
+			return err
+		}
+		ctx := c.UserContext()
+		rows, err := pool.Query(ctx, SQL_UNREAD_REPLIES, fmt.Sprint(claims["sub"]), limit, offset)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		if STREAM_LISTS {
+			return streamJSONArray(c, "comments", rows, shapeReplyRow)
+		}
+		defer rows.Close()
+		list := make([]map[string]any, 0)
+		for rows.Next() {
+			reply, err := shapeReplyRow(rows)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			list = append(list, reply)
+		}
+		return sendCollection(c, "comments", list)
+	})
+
+	app.Post("/auth/me/replies/seen", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+
+		ctx := c.UserContext()
+		var seenAt time.Time
+		if err := pool.QueryRow(ctx, SQL_REPLIES_SEEN, fmt.Sprint(claims["sub"])).Scan(&seenAt); err != nil {
+			return fiber.NewError(http.StatusUnauthorized, "Unauthorized")
+		}
+		return sendMeta(c, fiber.Map{"lastSeenRepliesAt": formatTime(seenAt)})
+	})
+
+	app.Post("/users", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		if err := requireAdmin(claims); err != nil {
+			return err
+		}
+
+		var body CreateUser
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		if err := validatePassword(body.Password); err != nil {
+			return fiber.NewError(http.StatusBadRequest, err.Error())
+		}
+		hash, err := hashPassword(body.Password)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Hash error")
+		}
+		ctx := c.UserContext()
+		viewer := newUserViewer(claims)
+		if err := viewer.loadAdmins(ctx, pool); err != nil {
+			return err
+		}
+		if !INSERT_THEN_SELECT {
+			// Single round trip: shape straight from the RETURNING row
+			row := pool.QueryRow(ctx, SQL_CREATE_USER_R, body.Username, body.Email, string(hash), nil)
+			user, err := viewer.shaper(shapeUserRow)(row)
+			if err != nil {
+				return fiber.NewError(http.StatusBadRequest, "Failed to create user")
+			}
+			location := "/users/" + user["id"].(string)
+			if prefersMinimal(c) {
+				return createdMinimal(c, location)
+			}
+			c.Set(fiber.HeaderLocation, location)
+			c.Status(http.StatusCreated)
+			return sendResource(c, "users", user)
+		}
+		var newID any
+		if err := pool.QueryRow(ctx, SQL_CREATE_USER, body.Username, body.Email, string(hash), nil).Scan(&newID); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Failed to create user")
+		}
+		location := "/users/" + uuidToString(newID)
+		if prefersMinimal(c) {
+			return createdMinimal(c, location)
+		}
+		row := pool.QueryRow(ctx, SQL_GET_USER, newID)
+		user, err := viewer.shaper(shapeUserRow)(row)
+		if err != nil {
+			return fiber.NewError(http.StatusNotFound, "User not found")
+		}
+		c.Set(fiber.HeaderLocation, location)
+		c.Status(http.StatusCreated)
+		return sendResource(c, "users", user)
+	})
+
+	app.Get("/auth/me/likes-received", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+
+		var since *time.Time
+		if v := c.Query("since"); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return fiber.NewError(http.StatusBadRequest, "Invalid since")
+			}
+			since = &t
+		}
+		limit, offset, err := parsePagination(c, "likes_received")
+		if err != nil {
+			return err
+		}
+		ctx := c.UserContext()
+		rows, err := pool.Query(ctx, SQL_LIKES_RECEIVED, fmt.Sprint(claims["sub"]), since, limit, offset)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		defer rows.Close()
+		list := make([]fiber.Map, 0)
+		for rows.Next() {
+			var postVal, likerVal any
+			var createdAt time.Time
+			var username string
+			if err := rows.Scan(&postVal, &createdAt, &likerVal, &username); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			list = append(list, fiber.Map{
+				"postId":    uuidToString(postVal),
+				"createdAt": formatTime(createdAt),
+				"liker":     fiber.Map{"id": uuidToString(likerVal), "username": username},
+			})
+		}
+		return sendMeta(c, list)
+	})
+
+	app.Post("/users/batch", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		if err := requireAdmin(claims); err != nil {
+			return err
+		}
+
+		var body []CreateUser
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		if len(body) > maxBatchSize {
+			return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("At most %d records allowed", maxBatchSize))
+		}
+		bestEffort := c.Query("mode") == "besteffort"
+		results, err := runBatchInsert(c.UserContext(), pool, len(body), bestEffort, func(ctx context.Context, tx pgx.Tx, i int) (string, error) {
+			if err := validatePassword(body[i].Password); err != nil {
+				return "", fiber.NewError(http.StatusBadRequest, err.Error())
+			}
+			hash, err := hashPassword(body[i].Password)
+			if err != nil {
+				return "", err
+			}
+			var id any
+			if err := tx.QueryRow(ctx, SQL_CREATE_USER, body[i].Username, body[i].Email, string(hash), nil).Scan(&id); err != nil {
+				return "", err
+			}
+			return uuidToString(id), nil
+		})
+		if err != nil {
+			return err
+		}
+		if bestEffort {
+			c.Status(http.StatusMultiStatus)
+		} else {
+			c.Status(http.StatusCreated)
+		}
+		return sendMeta(c, results)
+	})
+
+	app.Post("/users/bios/batch", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		if err := requireAdmin(claims); err != nil {
+			return err
+		}
+
+		var body []BioUpdate
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		if len(body) > maxBatchSize {
+			return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("At most %d records allowed", maxBatchSize))
+		}
+		ids := make([]string, len(body))
+		bios := make([]*string, len(body))
+		for i, u := range body {
+			if u.Bio != nil && utf8.RuneCountInString(*u.Bio) > maxBioLength {
+				return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("Bio too long at index %d", i))
+			}
+			ids[i], bios[i] = u.UserID, u.Bio
+		}
+		ctx := c.UserContext()
+		cmd, err := pool.Exec(ctx, SQL_UPDATE_BIOS, ids, bios)
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Failed to update bios")
+		}
+		return sendMeta(c, fiber.Map{"updated": cmd.RowsAffected()})
+	})
+
+	app.Get("/users", routeName("list_users"), listETag, func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		if err := requireAdmin(claims); err != nil {
+			return err
+		}
+		if LIST_ETAGS == "stats" {
+			if done, err := checkListETag(c, pool, SQL_USERS_STATS); done || err != nil {
+				return err
+			}
+		}
+
+		limit, offset, err := parsePagination(c, "users")
+		if err != nil {
+			return err
+		}
+		ctx, cancel := queryCtx(c)
+		viewer := newUserViewer(claims)
+		if err := viewer.loadAdmins(ctx, pool); err != nil {
+			cancel()
+			return err
+		}
+		if err := setRangeHeaders(c, pool, offset, limit, SQL_COUNT_USERS); err != nil {
+			cancel()
+			return err
+		}
+		shape := viewer.shaper(shapeUserRow)
+		rows, err := timedQuery(ctx, pool, SQL_LIST_USERS, limit, offset)
+		if err != nil {
+			cancel()
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		if STREAM_LISTS {
+			return streamJSONArray(c, "users", rowsWithCancel{rows, cancel}, shape)
+		}
+		defer cancel()
+		defer rows.Close()
+		list := make([]map[string]any, 0)
+		for rows.Next() {
+			user, err := shape(rows)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			list = append(list, user)
+		}
+		return sendCollection(c, "users", list)
+	})
+
+	app.Get("/users/inactive", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		if err := requireAdmin(claims); err != nil {
+			return err
+		}
+
+		limit, offset, err := parsePagination(c, "users")
+		if err != nil {
+			return err
+		}
+		ctx := c.UserContext()
+		viewer := newUserViewer(claims)
+		if err := viewer.loadAdmins(ctx, pool); err != nil {
+			return err
+		}
+		shape := viewer.shaper(shapeUserRow)
+		rows, err := pool.Query(ctx, SQL_INACTIVE_USERS, limit, offset)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		if STREAM_LISTS {
+			return streamJSONArray(c, "users", rows, shape)
+		}
+		defer rows.Close()
+		list := make([]map[string]any, 0)
+		for rows.Next() {
+			user, err := shape(rows)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			list = append(list, user)
+		}
+		return sendCollection(c, "users", list)
+	})
+
+	app.Get("/users/suggest", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		if _, err := decodeToken(tok); err != nil {
+			return err
+		}
+
+		q := c.Query("q")
+		if q == "" {
+			return fiber.NewError(http.StatusBadRequest, "q is required")
+		}
+		ctx := c.UserContext()
+		rows, err := pool.Query(ctx, SQL_SUGGEST_USERS, likePatternEscaper.Replace(q), userSuggestLimit)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		defer rows.Close()
+		list := make([]map[string]any, 0, userSuggestLimit)
+		for rows.Next() {
+			var id any
+			var username string
+			if err := rows.Scan(&id, &username); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			list = append(list, map[string]any{"id": uuidToString(id), "username": username})
+		}
+		return sendCollection(c, "users", list)
+	})
+
+	app.Get("/users/top", func(c *fiber.Ctx) error {
+		if by := c.Query("by", "likes"); by != "likes" {
+			return fiber.NewError(http.StatusBadRequest, "Unsupported ranking: "+by)
+		}
+		claims, err := optionalClaims(c)
+		if err != nil {
+			return err
+		}
+		limit := c.QueryInt("limit", defaultPageLimit)
+		if limit < 1 {
+			return fiber.NewError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+		limit = min(limit, maxLeaderboardSize)
+		ctx := c.UserContext()
+		viewer := newUserViewer(claims)
+		if err := viewer.loadAdmins(ctx, pool); err != nil {
+			return err
+		}
+		rows, err := pool.Query(ctx, SQL_TOP_BY_LIKES, limit)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		defer rows.Close()
+		list := make([]map[string]any, 0)
+		for rows.Next() {
+			var id any
+			var username, email string
+			var bio *string
+			var createdAt time.Time
+			var totalLikes int64
+			if err := rows.Scan(&id, &username, &email, &bio, &createdAt, &totalLikes); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			user := viewer.apply(userMap(id, username, email, bio, createdAt))
+			user["totalLikes"] = totalLikes
+			list = append(list, user)
+		}
+		return sendCollection(c, "users", list)
+	})
+
+	app.Get("/users/popular", func(c *fiber.Ctx) error {
+		claims, err := optionalClaims(c)
+		if err != nil {
+			return err
+		}
+		limit := c.QueryInt("limit", defaultPageLimit)
+		if limit < 1 {
+			return fiber.NewError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+		limit = min(limit, maxLeaderboardSize)
+		ctx := c.UserContext()
+		viewer := newUserViewer(claims)
+		if err := viewer.loadAdmins(ctx, pool); err != nil {
+			return err
+		}
+		rows, err := pool.Query(ctx, SQL_POPULAR_USERS, limit)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		defer rows.Close()
+		list := make([]map[string]any, 0)
+		for rows.Next() {
+			var id any
+			var username, email string
+			var bio *string
+			var createdAt time.Time
+			var followers int64
+			if err := rows.Scan(&id, &username, &email, &bio, &createdAt, &followers); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			user := viewer.apply(userMap(id, username, email, bio, createdAt))
+			user["followers"] = followers
+			list = append(list, user)
+		}
+		return sendCollection(c, "users", list)
+	})
+
+	app.Get("/users/:user_id/profile", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+
+		userID := c.Params("user_id")
+		ctx := c.UserContext()
+		viewer := newUserViewer(claims)
+		if err := viewer.loadAdmins(ctx, pool); err != nil {
+			return err
+		}
+		batch := &pgx.Batch{}
+		batch.Queue(SQL_GET_USER, userID)
+		batch.Queue(SQL_COUNT_POSTS_BY_AUTHOR, userID)
+		batch.Queue(SQL_COUNT_FOLLOWERS, userID)
+		batch.Queue(SQL_COUNT_FOLLOWING, userID)
+		batch.Queue(SQL_COUNT_LIKES_RECEIVED, userID)
+		conn, err := acquireConn(ctx, pool)
+		if err != nil {
+			return err
+		}
+		defer conn.Release()
+		results := conn.SendBatch(ctx, batch)
+		defer results.Close()
+
+		user, err := viewer.shaper(shapeUserRow)(results.QueryRow())
+		if err != nil {
+			return fiber.NewError(http.StatusNotFound, "User not found")
+		}
+		var posts, followers, following, likesReceived int64
+		for _, dst := range []*int64{&posts, &followers, &following, &likesReceived} {
+			if err := results.QueryRow().Scan(dst); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Query error")
+			}
+		}
+		user["counts"] = fiber.Map{
+			"posts":         posts,
+			"followers":     followers,
+			"following":     following,
+			"likesReceived": likesReceived,
+		}
+		return sendResource(c, "users", user)
+	})
+
+	app.Get("/users/:user_id/posts", func(c *fiber.Ctx) error {
+		userID := c.Params("user_id")
+		limit, offset, err := parsePagination(c, "user_posts")
+		if err != nil {
+			return err
+		}
+		if err := setRangeHeaders(c, pool, offset, limit, SQL_COUNT_POSTS_BY_AUTHOR, userID); err != nil {
+			return err
+		}
+		ctx := c.UserContext()
+		rows, err := pool.Query(ctx, SQL_POSTS_BY_AUTHOR, userID, limit, offset)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		if STREAM_LISTS {
+			return streamJSONArray(c, "posts", rows, shapePinnedPostRow)
+		}
+		defer rows.Close()
+		list := make([]map[string]any, 0)
+		for rows.Next() {
+			post, err := shapePinnedPostRow(rows)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			list = append(list, post)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid user id")
+		}
+		return sendCollection(c, "posts", list)
+	})
+
+	app.Get("/users/:user_id/influence", func(c *fiber.Ctx) error {
+		userID := c.Params("user_id")
+		ctx := c.UserContext()
+		var followers, likesReceived, posts int64
+		if err := pool.QueryRow(ctx, SQL_INFLUENCE, userID).Scan(&followers, &likesReceived, &posts); err != nil {
+			return fiber.NewError(http.StatusNotFound, "User not found")
+		}
+		return sendMeta(c, fiber.Map{
+			"userId":        userID,
+			"score":         influenceScore(followers, likesReceived, posts),
+			"followers":     followers,
+			"likesReceived": likesReceived,
+			"posts":         posts,
+		})
+	})
+
+	app.Get("/users/:user_id/mentions", func(c *fiber.Ctx) error {
+		userID := c.Params("user_id")
+		limit, offset, err := parsePagination(c, "mentions")
+		if err != nil {
+			return err
+		}
+		ctx := c.UserContext()
+		shape := postShaper(c)
+		rows, err := pool.Query(ctx, SQL_MENTIONS, userID, limit, offset)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		if STREAM_LISTS {
+			return streamJSONArray(c, "posts", rows, shape)
+		}
+		defer rows.Close()
+		list := make([]map[string]any, 0)
+		for rows.Next() {
+			post, err := shape(rows)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			list = append(list, post)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid user id")
+		}
+		return sendCollection(c, "posts", list)
+	})
+
+	app.Get("/users/:user_id/commented-posts", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		userID := c.Params("user_id")
+		if err := requireSelfOrAdmin(claims, userID); err != nil {
+			return err
+		}
+
+		limit, offset, err := parsePagination(c, "commented_posts")
+		if err != nil {
+			return err
+		}
+		ctx := c.UserContext()
+		shape := postShaper(c)
+		rows, err := pool.Query(ctx, SQL_LIST_COMMENTED, userID, limit, offset)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		if STREAM_LISTS {
+			return streamJSONArray(c, "posts", rows, shape)
+		}
+		defer rows.Close()
+		list := make([]map[string]any, 0)
+		for rows.Next() {
+			post, err := shape(rows)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			list = append(list, post)
+		}
+		return sendCollection(c, "posts", list)
+	})
+
+	app.Get("/users/:user_id/mutual-posts/:other_id", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		userID := c.Params("user_id")
+		otherID := c.Params("other_id")
+		if err := requireSelfOrAdmin(claims, userID); err != nil {
+			if err := requireSelfOrAdmin(claims, otherID); err != nil {
+				return err
+			}
+		}
+
+		limit, offset, err := parsePagination(c, "mutual_posts")
+		if err != nil {
+			return err
+		}
+		ctx := c.UserContext()
+		shape := postShaper(c)
+		rows, err := pool.Query(ctx, SQL_MUTUAL_POSTS, userID, otherID, limit, offset)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		if STREAM_LISTS {
+			return streamJSONArray(c, "posts", rows, shape)
+		}
+		defer rows.Close()
+		list := make([]map[string]any, 0)
+		for rows.Next() {
+			post, err := shape(rows)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			list = append(list, post)
+		}
+		return sendCollection(c, "posts", list)
+	})
+
+	app.Get("/users/:user_id/activity", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		userID := c.Params("user_id")
+		if err := requireSelfOrAdmin(claims, userID); err != nil {
+			return err
+		}
+
+		var before *activityCursor
+		if v := c.Query("before"); v != "" {
+			if before, err = parseActivityCursor(v); err != nil {
+				return fiber.NewError(http.StatusBadRequest, "Invalid cursor")
+			}
+		}
+		limit, _, err := parsePagination(c, "activity")
+		if err != nil {
+			return err
+		}
+		ctx := c.UserContext()
+		var beforeAt *time.Time
+		var beforeKind, beforeKey *string
+		if before != nil {
+			beforeAt, beforeKind, beforeKey = &before.createdAt, &before.kind, &before.key
+		}
+		rows, err := pool.Query(ctx, SQL_USER_ACTIVITY, userID, beforeAt, beforeKind, beforeKey, limit)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		defer rows.Close()
+		items := make([]map[string]any, 0)
+		var last activityCursor
+		for rows.Next() {
+			var kind string
+			var idVal, postVal, keyVal any
+			var content *string
+			var createdAt time.Time
+			if err := rows.Scan(&kind, &idVal, &postVal, &content, &createdAt, &keyVal); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			item := map[string]any{
+				"type":      kind,
+				"postId":    uuidToString(postVal),
+				"createdAt": formatTime(createdAt),
+			}
+			// Likes have no id or content of their own
+			if idVal != nil {
+				item["id"] = uuidToString(idVal)
+			}
+			if content != nil {
+				item["content"] = *content
+			}
+			items = append(items, item)
+			last = activityCursor{createdAt: createdAt, kind: kind, key: uuidToString(keyVal)}
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		var nextCursor *string
+		if len(items) == limit {
+			cursor := last.encode()
+			nextCursor = &cursor
+		}
+		return sendMeta(c, fiber.Map{"items": items, "nextCursor": nextCursor})
+	})
+
+	// listFollows serves both directions of the follow graph for a user, and their
+	// intersection; selfOnly limits a list to the user themselves and admins
+	listFollows := func(route, query, countSQL string, selfOnly bool) fiber.Handler {
+		return func(c *fiber.Ctx) error {
+			tok, err := getTokenFromHeader(c)
+			if err != nil {
+				return err
+			}
+			claims, err := decodeToken(tok)
+			if err != nil {
+				return err
+			}
+
+			userID := c.Params("user_id")
+			if selfOnly {
+				if err := requireSelfOrAdmin(claims, userID); err != nil {
+					return err
+				}
+			}
+			limit, offset, err := parsePagination(c, route)
+			if err != nil {
+				return err
+			}
+			ctx := c.UserContext()
+			viewer := newUserViewer(claims)
+			if err := viewer.loadAdmins(ctx, pool); err != nil {
+				return err
+			}
+			shape := viewer.shaper(shapeUserRow)
+			var exists bool
+			if err := pool.QueryRow(ctx, SQL_USER_EXISTS, userID).Scan(&exists); err != nil || !exists {
+				return fiber.NewError(http.StatusNotFound, "User not found")
+			}
+			if err := setRangeHeaders(c, pool, offset, limit, countSQL, userID); err != nil {
+				return err
+			}
+			rows, err := pool.Query(ctx, query, userID, limit, offset)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Query error")
+			}
+			if STREAM_LISTS {
+				return streamJSONArray(c, "users", rows, shape)
+			}
+			defer rows.Close()
+			list := make([]map[string]any, 0)
+			for rows.Next() {
+				user, err := shape(rows)
+				if err != nil {
+					return fiber.NewError(http.StatusInternalServerError, "Scan error")
+				}
+				list = append(list, user)
+			}
+			return sendCollection(c, "users", list)
+		}
+	}
+
+	app.Post("/users/:user_id/follow", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+
+		followerID := fmt.Sprint(claims["sub"])
+		followeeID := c.Params("user_id")
+		if followerID == followeeID {
+			return fiber.NewError(http.StatusBadRequest, "Cannot follow yourself")
+		}
+		ctx := c.UserContext()
+		if MAX_FOLLOWING > 0 {
+			var count int
+			if err := pool.QueryRow(ctx, SQL_COUNT_FOLLOWING, followerID).Scan(&count); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Query error")
+			}
+			if count >= MAX_FOLLOWING {
+				return fiber.NewError(http.StatusUnprocessableEntity, "Follow limit reached")
+			}
+		}
+		cmd, err := pool.Exec(ctx, SQL_CREATE_FOLLOW, followerID, followeeID)
+		if err != nil {
+			if isMissingRefErr(err) {
+				return fiber.NewError(http.StatusNotFound, "User not found")
+			}
+			return fiber.NewError(http.StatusInternalServerError, "Failed to follow")
+		}
+		if cmd.RowsAffected() == 0 {
+			return fiber.NewError(http.StatusConflict, "Already following")
+		}
+		return sendNoContent(c)
+	})
+
+	app.Get("/users/:user_id/followers", listFollows("followers", SQL_LIST_FOLLOWERS, SQL_COUNT_FOLLOWERS, false))
+	app.Get("/users/:user_id/following", listFollows("following", SQL_LIST_FOLLOWING, SQL_COUNT_FOLLOWING, false))
+	app.Get("/users/:user_id/mutuals", listFollows("mutuals", SQL_LIST_MUTUALS, SQL_COUNT_MUTUALS, true))
+
+	// bio is the only writable field, so PUT and PATCH differ only in that PATCH
+	// refuses a body that leaves it out instead of clearing it
+	updateUser := func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		if err := requireAdmin(claims); err != nil {
+			return err
+		}
+
+		userID := c.Params("user_id")
+		var body UpdateUser
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		if c.Method() == fiber.MethodPatch {
+			var fields map[string]json.RawMessage
+			if json.Unmarshal(c.Body(), &fields) != nil {
+				return fiber.NewError(http.StatusBadRequest, "Invalid body")
+			}
+			if _, ok := fields["bio"]; !ok {
+				return fiber.NewError(http.StatusBadRequest, "Nothing to update")
+			}
+		}
+		expected, err := parseIfMatchVersion(c.Get(fiber.HeaderIfMatch))
+		if err != nil {
+			return fiber.NewError(http.StatusPreconditionFailed, "Version mismatch")
+		}
+		ctx := c.UserContext()
+		viewer := newUserViewer(claims)
+		if err := viewer.loadAdmins(ctx, pool); err != nil {
+			return err
+		}
+		row := pool.QueryRow(ctx, SQL_UPDATE_USER_V, userID, body.Bio, expected)
+		user, version, err := shapeVersionedUserRow(row)
+		if err != nil {
+			// No row either means the user is gone or the version is stale
+			var exists bool
+			if expected != nil && pool.QueryRow(ctx, SQL_USER_EXISTS, userID).Scan(&exists) == nil && exists {
+				return fiber.NewError(http.StatusPreconditionFailed, "Version mismatch")
+			}
+			return fiber.NewError(http.StatusNotFound, "User not found")
+		}
+		c.Set(fiber.HeaderETag, `"`+strconv.FormatInt(version, 10)+`"`)
+		return sendResource(c, "users", viewer.apply(user))
+	}
+	app.Put("/users/:user_id", updateUser)
+	app.Patch("/users/:user_id", updateUser)
+
+	app.Delete("/users/:user_id", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		if err := requireAdmin(claims); err != nil {
+			return err
+		}
+
+		userID := c.Params("user_id")
+		ctx := c.UserContext()
+		cmd, err := pool.Exec(ctx, SQL_DELETE_USER, userID)
+		if err != nil || cmd.RowsAffected() != 1 {
+			return fiber.NewError(http.StatusNotFound, "User not found")
+		}
+		return sendNoContent(c)
+	})
+
+	broker := newPostBroker(SSE_MAX_SUBSCRIBERS)
+
+	app.Post("/posts", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+
+		var body PostCreate
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		status := body.Status
+		if status == "" {
+			status = "published"
+		}
+		if status != "published" && status != "draft" {
+			return fiber.NewError(http.StatusBadRequest, "status must be draft or published")
+		}
+		autolike := c.QueryBool("autolike")
+		if autolike && status == "draft" {
+			return fiber.NewError(http.StatusBadRequest, "Drafts cannot be autoliked")
+		}
+		userID := fmt.Sprint(claims["sub"])
+		ctx := c.UserContext()
+		if POST_COOLDOWN_MS > 0 {
+			var lastPostAt *time.Time
+			if err := pool.QueryRow(ctx, SQL_LAST_POST_AT, userID).Scan(&lastPostAt); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Query error")
+			}
+			cooldown := time.Duration(POST_COOLDOWN_MS) * time.Millisecond
+			if lastPostAt != nil {
+				if wait := cooldown - time.Since(*lastPostAt); wait > 0 {
+					setRetryAfter(c, wait)
+					return fiber.NewError(http.StatusTooManyRequests, "Posting too frequently")
+				}
+			}
+		}
+		if DEDUP_POSTS {
+			var duplicate bool
+			if err := pool.QueryRow(ctx, SQL_DUPLICATE_POST, userID, body.Content).Scan(&duplicate); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Query error")
+			}
+			if duplicate {
+				return fiber.NewError(http.StatusConflict, "Duplicate post")
+			}
+		}
+		// ?autolike runs the insert and the author's like in one transaction; any
+		// failure rolls both back
+		var db rowQuerier = pool
+		var tx pgx.Tx
+		if autolike {
+			if MAX_LIKES_PER_USER > 0 {
+				var count int
+				if err := pool.QueryRow(ctx, SQL_COUNT_LIKES_BY, userID).Scan(&count); err != nil {
+					return fiber.NewError(http.StatusInternalServerError, "Query error")
+				}
+				if count >= MAX_LIKES_PER_USER {
+					return fiber.NewError(http.StatusTooManyRequests, "Like limit reached")
+				}
+			}
+			var err error
+			if tx, err = pool.Begin(ctx); err != nil {
+				return fiber.NewError(http.StatusServiceUnavailable, "Database unavailable")
+			}
+			defer tx.Rollback(ctx)
+			db = tx
+		}
+		var row pgx.Row
+		if status == "draft" {
+			row = db.QueryRow(ctx, SQL_CREATE_WITH_STATUS, withCreatedAt(userID, body.Content, status)...)
+		} else {
+			row = db.QueryRow(ctx, SQL_CREATE_POST, withCreatedAt(userID, body.Content)...)
+		}
+		var idVal, authorVal any
+		var content string
+		var createdAt time.Time
+		if err := row.Scan(&idVal, &authorVal, &content, &createdAt); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Failed to create post")
+		}
+		post := map[string]any{
+			"id":        uuidToString(idVal),
+			"authorId":  uuidToString(authorVal),
+			"content":   content,
+			"createdAt": formatTime(createdAt),
+			"likeCount": 0,
+			"status":    status,
+		}
+		if autolike {
+			if _, err := tx.Exec(ctx, SQL_CREATE_LIKE, userID, idVal); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Failed to like post")
+			}
+			if err := tx.Commit(ctx); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Commit error")
+			}
+			post["likeCount"] = 1
+			post["liked"] = true
+		}
+		// Drafts reach stream subscribers when they are published
+		if status == "published" {
+			broker.publish(post)
+		}
+		location := "/posts/" + uuidToString(idVal)
+		if prefersMinimal(c) {
+			return createdMinimal(c, location)
+		}
+		c.Set(fiber.HeaderLocation, location)
+		c.Status(http.StatusCreated)
+		return sendResource(c, "posts", post)
+	})
+
+	var postsCache *feedCache
+	if POSTS_CACHE_TTL_MS > 0 {
+		postsCache = &feedCache{ttl: time.Duration(POSTS_CACHE_TTL_MS) * time.Millisecond}
+	}
+
+	app.Get("/posts", routeName("list_posts"), listETag, func(c *fiber.Ctx) error {
+		if LIST_ETAGS == "stats" {
+			if done, err := checkListETag(c, pool, SQL_POSTS_STATS); done || err != nil {
+				return err
+			}
+		}
+		var minLikes *int
+		if v := c.Query("minLikes"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return fiber.NewError(http.StatusBadRequest, "minLikes must be a non-negative integer")
+			}
+			minLikes = &n
+		}
+		// Accept: application/x-ndjson streams one post per line straight off the rows.
+		// Headers go out before the first row, so there is no X-Next-Cursor to hand back.
+		ndjson := acceptsNDJSON(c)
+		c.Vary(fiber.HeaderAccept)
+		if ndjson && c.Query("cursor") != "" {
+			return fiber.NewError(http.StatusBadRequest, "cursor is not supported with application/x-ndjson")
+		}
+		// SNAPSHOT_PAGINATION serves the default ordering by keyset: each page carries
+		// X-Next-Cursor, and posts newer than the first page never enter the scan.
+		// Checked before the cache so that page one also gets its cursor.
+		if SNAPSHOT_PAGINATION && !ndjson && c.Query("sort") == "" && c.Query("counts") == "" {
+			limit, offset, err := parsePagination(c, "posts")
+			if err != nil {
+				return err
+			}
+			var after *postCursor
+			if token := c.Query("cursor"); token != "" {
+				var err error
+				if after, err = parsePostCursor(token); err != nil {
+					return fiber.NewError(http.StatusBadRequest, "Invalid cursor")
+				}
+			}
+			if after != nil || offset == 0 {
+				var asOf, createdAt *time.Time
+				var id *string
+				if after != nil {
+					asOf, createdAt, id = &after.asOf, &after.createdAt, &after.id
+				}
+				ctx, cancel := queryCtx(c)
+				defer cancel()
+				rows, err := timedQuery(ctx, pool, SQL_LIST_KEYSET, asOf, createdAt, id, limit, minLikes)
+				if err != nil {
+					return fiber.NewError(http.StatusInternalServerError, "Query error")
+				}
+				defer rows.Close()
+				var last postCursor
+				shape := withPostStats(c, keysetPostShaper(&last))
+				list := make([]map[string]any, 0)
+				for rows.Next() {
+					post, err := shape(rows)
+					if err != nil {
+						return fiber.NewError(http.StatusInternalServerError, "Scan error")
+					}
+					if len(list) == 0 {
+						last.asOf = last.createdAt
+					}
+					list = append(list, post)
+				}
+				if err := rows.Err(); err != nil {
+					return fiber.NewError(http.StatusInternalServerError, "Query error")
+				}
+				if after != nil {
+					last.asOf = after.asOf
+				}
+				if len(list) == limit {
+					c.Set("X-Next-Cursor", last.encode())
+				}
+				return sendCollection(c, "posts", list)
+			}
+		}
+		if RANGE_HEADERS {
+			limit, offset, err := parsePagination(c, "posts")
+			if err != nil {
+				return err
+			}
+			if err := setRangeHeaders(c, pool, offset, limit, SQL_COUNT_PUBLISHED, minLikes); err != nil {
+				return err
+			}
+		}
+		// Only the exact default query (no params at all) is served from cache
+		if postsCache != nil && !ndjson && len(c.Request().URI().QueryString()) == 0 {
+			body, err := postsCache.get(func() ([]byte, error) {
+				limit, offset, err := parsePagination(c, "posts")
+				if err != nil {
+					return nil, err
+				}
+				rows, err := pool.Query(context.Background(), SQL_LIST_POSTS, limit, offset)
+				if err != nil {
+					return nil, err
+				}
+				defer rows.Close()
+				list := make([]map[string]any, 0)
+				for rows.Next() {
+					post, err := shapePostRow(rows)
+					if err != nil {
+						return nil, err
+					}
+					list = append(list, post)
+				}
+				if err := rows.Err(); err != nil {
+					return nil, err
+				}
+				return json.Marshal(collectionDocument("posts", list))
+			})
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Query error")
+			}
+			c.Set(fiber.HeaderContentType, responseContentType())
+			return c.Send(body)
+		}
+
+		limit, offset, err := parsePagination(c, "posts")
+		if err != nil {
+			return err
+		}
+		query := SQL_LIST_POSTS
+		shape := postShaper(c)
+		template, orderBy := SQL_LIST_SORTED, ""
+		switch c.Query("counts") {
+		case "":
+		case "full":
+			template, orderBy = SQL_LIST_COUNTED, "p.created_at DESC"
+			shape = withPostStats(c, shapeCountedPostRow)
+		default:
+			return fiber.NewError(http.StatusBadRequest, "counts must be full")
+		}
+		if spec := c.Query("sort"); spec != "" {
+			var err error
+			if orderBy, err = parsePostSort(spec); err != nil {
+				return fiber.NewError(http.StatusBadRequest, err.Error())
+			}
+		}
+		// list.sql is shared with the other implementations, so filtering goes through the template
+		if minLikes != nil && orderBy == "" {
+			orderBy = "p.created_at DESC"
+		}
+		args := []any{limit, offset}
+		if orderBy != "" {
+			query = strings.Replace(template, "{{ORDER_BY}}", orderBy, 1)
+			args = append(args, minLikes)
+		}
+		ctx, cancel := queryCtx(c)
+		rows, err := timedQuery(ctx, pool, query, args...)
+		if err != nil {
+			cancel()
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		if ndjson {
+			return streamNDJSON(c, "posts", rowsWithCancel{rows, cancel}, shape)
+		}
+		if STREAM_LISTS {
+			return streamJSONArray(c, "posts", rowsWithCancel{rows, cancel}, shape)
+		}
+		defer cancel()
+		defer rows.Close()
+		list := make([]map[string]any, 0)
+		for rows.Next() {
+			post, err := shape(rows)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			list = append(list, post)
+		}
+		return sendCollection(c, "posts", list)
+	})
+
+	app.Get("/posts/stream", func(c *fiber.Ctx) error {
+		ch, ok := broker.subscribe()
+		if !ok {
+			return fiber.NewError(http.StatusServiceUnavailable, "Too many subscribers")
+		}
+		c.Set(fiber.HeaderContentType, "text/event-stream")
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		c.Set(fiber.HeaderConnection, "keep-alive")
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer broker.unsubscribe(ch)
+			// Comment lines keep idle connections open and surface disconnects on flush
+			heartbeat := time.NewTicker(15 * time.Second)
+			defer heartbeat.Stop()
+			w.WriteString(": connected\n\n")
+			if err := w.Flush(); err != nil {
+				return
+			}
+			for {
+				select {
+				case msg := <-ch:
+					w.WriteString("data: ")
+					w.Write(msg)
+					w.WriteString("\n\n")
+				case <-heartbeat.C:
+					w.WriteString(": ping\n\n")
+				}
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		})
+		return nil
+	})
+
+	app.Get("/posts/random", func(c *fiber.Ctx) error {
+		count := c.QueryInt("count", defaultPageLimit)
+		if count < 1 {
+			return fiber.NewError(http.StatusBadRequest, "count must be a positive integer")
+		}
+		count = min(count, maxRandomPosts)
+		ctx := c.UserContext()
+		var rows pgx.Rows
+		var err error
+		switch c.Query("method", "order") {
+		case "order":
+			rows, err = pool.Query(ctx, SQL_RANDOM_POSTS, count)
+		case "tablesample":
+			rows, err = pool.Query(ctx, SQL_SAMPLE_POSTS, count, tableSamplePercent)
+		default:
+			return fiber.NewError(http.StatusBadRequest, "method must be order or tablesample")
+		}
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		defer rows.Close()
+		shape := postShaper(c)
+		list := make([]map[string]any, 0, count)
+		for rows.Next() {
+			post, err := shape(rows)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			list = append(list, post)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		return sendCollection(c, "posts", list)
+	})
+
+	// ENABLE_SINGLEFLIGHT coalesces identical concurrent reads of public, caller
+	// independent resources: one query runs and every waiter shares its result.
+	var readGroup singleflight.Group
+
+	app.Get("/posts/:post_id", routeName("get_post"), func(c *fiber.Ctx) error {
+		postID := c.Params("post_id")
+		load := func() (map[string]any, error) {
+			ctx, cancel := queryCtx(c)
+			defer cancel()
+			return postShaper(c)(timedQueryRow(ctx, pool, SQL_GET_POST, postID))
+		}
+		var post map[string]any
+		var err error
+		if ENABLE_SINGLEFLIGHT {
+			// The query string is part of the key since ?stats changes the shape. Waiters
+			// inherit the leader's deadline; the shared map is only ever read.
+			key := c.Path() + "?" + string(c.Request().URI().QueryString())
+			var v any
+			v, err, _ = readGroup.Do(key, func() (any, error) { return load() })
+			if err == nil {
+				post = v.(map[string]any)
+			}
+		} else {
+			post, err = load()
+		}
+		if err != nil {
+			return fiber.NewError(http.StatusNotFound, "Post not found")
+		}
+		return sendResource(c, "posts", post)
+	})
+
+	app.Delete("/posts/:post_id", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+
+		postID := c.Params("post_id")
+		ctx := c.UserContext()
+		var authorID any
+		if err := pool.QueryRow(ctx, SQL_GET_POST_AUTH, postID).Scan(&authorID); err != nil {
+			return fiber.NewError(http.StatusNotFound, "Post not found")
+		}
+		if uuidToString(authorID) != fmt.Sprint(claims["sub"]) {
+			if err := requireAdmin(claims); err != nil {
+				return err
+			}
+		}
+		if _, err := pool.Exec(ctx, SQL_DELETE_POST, postID); err != nil {
+			return fiber.NewError(http.StatusNotFound, "Post not found")
+		}
+		return sendNoContent(c)
+	})
+
+	app.Get("/posts/:post_id/engagement", func(c *fiber.Ctx) error {
+		// Anonymous callers get the counts; "liked" needs a token
+		claims, err := optionalClaims(c)
+		if err != nil {
+			return err
+		}
+		var userID string
+		if claims != nil {
+			userID = fmt.Sprint(claims["sub"])
+		}
+
+		postID := c.Params("post_id")
+		ctx := c.UserContext()
+		batch := &pgx.Batch{}
+		batch.Queue(SQL_GET_POST, postID)
+		batch.Queue(SQL_COUNT_COMMENTS_BY_POST, postID)
+		if userID != "" {
+			batch.Queue(SQL_LIKE_EXISTS, userID, postID)
+		}
+		conn, err := acquireConn(ctx, pool)
+		if err != nil {
+			return err
+		}
+		defer conn.Release()
+		results := conn.SendBatch(ctx, batch)
+		defer results.Close()
+
+		post, err := shapePostRow(results.QueryRow())
+		if err != nil {
+			return fiber.NewError(http.StatusNotFound, "Post not found")
+		}
+		var commentCount int64
+		if err := results.QueryRow().Scan(&commentCount); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		engagement := fiber.Map{
+			"likeCount":    post["likeCount"],
+			"commentCount": commentCount,
+		}
+		if userID != "" {
+			var one int
+			err := results.QueryRow().Scan(&one)
+			if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				return fiber.NewError(http.StatusInternalServerError, "Query error")
+			}
+			engagement["liked"] = err == nil
+		}
+		return sendMeta(c, engagement)
+	})
+
+	app.Get("/posts/:post_id/full", func(c *fiber.Ctx) error {
+		claims, err := optionalClaims(c)
+		if err != nil {
+			return err
+		}
+
+		postID := c.Params("post_id")
+		ctx := c.UserContext()
+		viewer := newUserViewer(claims)
+		if err := viewer.loadAdmins(ctx, pool); err != nil {
+			return err
+		}
+		// Everything for the permalink page goes out in one batch on one connection
+		pageSize := defaultPageLimit
+		batch := &pgx.Batch{}
+		batch.Queue(SQL_GET_POST, postID)
+		batch.Queue(SQL_GET_POST_AUTHOR_USER, postID)
+		batch.Queue(SQL_LIST_COMM_PAGE, postID, pageSize, 0)
+		batch.Queue(SQL_LIST_LIKERS, postID, pageSize)
+		conn, err := acquireConn(ctx, pool)
+		if err != nil {
+			return err
+		}
+		defer conn.Release()
+		results := conn.SendBatch(ctx, batch)
+		defer results.Close()
+
+		post, err := postShaper(c)(results.QueryRow())
+		if err != nil {
+			return fiber.NewError(http.StatusNotFound, "Post not found")
+		}
+		author, err := viewer.shaper(shapeUserRow)(results.QueryRow())
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		rows, err := results.Query()
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		comments := make([]map[string]any, 0)
+		for rows.Next() {
+			comment, err := shapeCommentRow(rows)
+			if err != nil {
+				rows.Close()
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			comments = append(comments, comment)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		rows, err = results.Query()
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		likers := make([]map[string]any, 0)
+		for rows.Next() {
+			var id any
+			var username string
+			var likedAt time.Time
+			if err := rows.Scan(&id, &username, &likedAt); err != nil {
+				rows.Close()
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			likers = append(likers, map[string]any{
+				"id":       uuidToString(id),
+				"username": username,
+				"likedAt":  formatTime(likedAt),
+			})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		return sendMeta(c, fiber.Map{
+			"post":     post,
+			"author":   author,
+			"comments": comments,
+			"likers":   likers,
+		})
+	})
+
+	setPinned := func(pinned bool) fiber.Handler {
+		return func(c *fiber.Ctx) error {
+			tok, err := getTokenFromHeader(c)
+			if err != nil {
+				return err
+			}
+			claims, err := decodeToken(tok)
+			if err != nil {
+				return err
+			}
+
+			postID := c.Params("post_id")
+			ctx := c.UserContext()
+			var authorID any
+			if err := pool.QueryRow(ctx, SQL_GET_POST_AUTH, postID).Scan(&authorID); err != nil {
+				return fiber.NewError(http.StatusNotFound, "Post not found")
+			}
+			if uuidToString(authorID) != fmt.Sprint(claims["sub"]) {
+				return fiber.ErrForbidden
+			}
+			if pinned {
+				var count int
+				if err := pool.QueryRow(ctx, SQL_COUNT_PINNED, authorID, postID).Scan(&count); err != nil {
+					return fiber.NewError(http.StatusInternalServerError, "Query error")
+				}
+				if count >= maxPinnedPosts {
+					return fiber.NewError(http.StatusUnprocessableEntity, "Pin limit reached")
+				}
+			}
+			if _, err := pool.Exec(ctx, SQL_SET_PINNED, postID, pinned); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Failed to update post")
+			}
+			return sendNoContent(c)
+		}
+	}
+
+	app.Post("/posts/:post_id/pin", setPinned(true))
+	app.Delete("/posts/:post_id/pin", setPinned(false))
+
+	app.Post("/posts/:post_id/view", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+
+		// Repeat views are no-ops, so the call is idempotent
+		if _, err := pool.Exec(c.UserContext(), SQL_RECORD_VIEW, fmt.Sprint(claims["sub"]), c.Params("post_id")); err != nil {
+			if isMissingRefErr(err) {
+				return fiber.NewError(http.StatusNotFound, "Post not found")
+			}
+			return fiber.NewError(http.StatusInternalServerError, "Failed to record view")
+		}
+		return sendNoContent(c)
+	})
+
+	app.Post("/posts/:post_id/publish", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+
+		postID := c.Params("post_id")
+		ctx := c.UserContext()
+		var authorID any
+		if err := pool.QueryRow(ctx, SQL_GET_POST_AUTH, postID).Scan(&authorID); err != nil {
+			return fiber.NewError(http.StatusNotFound, "Post not found")
+		}
+		if uuidToString(authorID) != fmt.Sprint(claims["sub"]) {
+			return fiber.ErrForbidden
+		}
+		var idVal, authorVal any
+		var content string
+		var createdAt, publishedAt time.Time
+		var likeCount int64
+		err = pool.QueryRow(ctx, SQL_PUBLISH_POST, postID).Scan(&idVal, &authorVal, &content, &createdAt, &likeCount, &publishedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fiber.NewError(http.StatusConflict, "Post already published")
+		}
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Failed to publish post")
+		}
+		post := map[string]any{
+			"id":          uuidToString(idVal),
+			"authorId":    uuidToString(authorVal),
+			"content":     content,
+			"likeCount":   int(likeCount),
+			"createdAt":   formatTime(createdAt),
+			"status":      "published",
+			"publishedAt": formatTime(publishedAt),
+		}
+		broker.publish(post)
+		return sendResource(c, "posts", post)
+	})
+
+	app.Post("/posts/:post_id/transfer", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		if err := requireAdmin(claims); err != nil {
+			return err
+		}
+
+		postID := c.Params("post_id")
+		var body PostTransfer
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		ctx := c.UserContext()
+		var exists bool
+		if err := pool.QueryRow(ctx, SQL_USER_EXISTS, body.NewAuthorID).Scan(&exists); err != nil || !exists {
+			return fiber.NewError(http.StatusNotFound, "User not found")
+		}
+		row := pool.QueryRow(ctx, SQL_TRANSFER_POST, postID, body.NewAuthorID)
+		post, err := shapePostRow(row)
+		if err != nil {
+			return fiber.NewError(http.StatusNotFound, "Post not found")
+		}
+		return sendResource(c, "posts", post)
+	})
+
+	var commentQueue *commentBuffer
+	if COMMENT_BATCH_MS > 0 {
+		commentQueue = &commentBuffer{pool: pool}
+		go commentQueue.run(time.Duration(COMMENT_BATCH_MS) * time.Millisecond)
+	}
+
+	app.Post("/posts/:post_id/comments", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		postID := c.Params("post_id")
+		ctx := c.UserContext()
+		if INSERT_THEN_SELECT {
+			// Ensure post exists
+			var one int
+			if err := pool.QueryRow(ctx, "SELECT 1 FROM posts WHERE id = $1 AND status = 'published'", postID).Scan(&one); err != nil {
+				return fiber.NewError(http.StatusNotFound, "Post not found")
+			}
+		}
+		var body CommentCreate
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		if MAX_COMMENTS_PER_POST > 0 {
+			// Count-then-insert is not atomic; concurrent writers can overshoot the cap slightly
+			var count int
+			if err := pool.QueryRow(ctx, SQL_COUNT_COMMENTS_BY_POST, postID).Scan(&count); err != nil {
+				if isMissingRefErr(err) {
+					return fiber.NewError(http.StatusNotFound, "Post not found")
+				}
+				return fiber.NewError(http.StatusInternalServerError, "Query error")
+			}
+			if count >= MAX_COMMENTS_PER_POST {
+				return fiber.NewError(http.StatusUnprocessableEntity, "Comment limit reached")
+			}
+		}
+		if commentQueue != nil {
+			// The client only gets a 202, so anything the insert would reject is caught here
+			if !INSERT_THEN_SELECT {
+				var one int
+				if err := pool.QueryRow(ctx, "SELECT 1 FROM posts WHERE id = $1 AND status = 'published'", postID).Scan(&one); err != nil {
+					return fiber.NewError(http.StatusNotFound, "Post not found")
+				}
+			}
+			if body.ParentID != nil {
+				var parentAuthor any
+				if err := pool.QueryRow(ctx, SQL_GET_COMM_AUTH, *body.ParentID, postID).Scan(&parentAuthor); err != nil {
+					return fiber.NewError(http.StatusBadRequest, "Parent comment not found")
+				}
+			}
+			if body.ParentID != nil {
+				commentQueue.add(SQL_CREATE_REPLY, withCreatedAt(fmt.Sprint(claims["sub"]), postID, body.Content, *body.ParentID)...)
+			} else {
+				commentQueue.add(SQL_CREATE_COMMENT, withCreatedAt(fmt.Sprint(claims["sub"]), postID, body.Content)...)
+			}
+			c.Status(http.StatusAccepted)
+			return sendMeta(c, fiber.Map{"tempId": body.TempID, "postId": postID, "status": "queued"})
+		}
+		var row pgx.Row
+		if body.ParentID != nil {
+			row = pool.QueryRow(ctx, SQL_CREATE_REPLY, withCreatedAt(fmt.Sprint(claims["sub"]), postID, body.Content, *body.ParentID)...)
+		} else {
+			row = pool.QueryRow(ctx, SQL_CREATE_COMMENT, withCreatedAt(fmt.Sprint(claims["sub"]), postID, body.Content)...)
+		}
+		comment, err := shapeCommentRow(row)
+		if err != nil {
+			// Without the up-front existence check a missing post surfaces from the insert
+			if isMissingRefErr(err) {
+				return fiber.NewError(http.StatusNotFound, "Post not found")
+			}
+			if body.ParentID != nil && errors.Is(err, pgx.ErrNoRows) {
+				return fiber.NewError(http.StatusBadRequest, "Parent comment not found")
+			}
+			// The insert skips posts that are missing or still drafts
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fiber.NewError(http.StatusNotFound, "Post not found")
+			}
+			return fiber.NewError(http.StatusBadRequest, "Failed to create comment")
+		}
+		if body.ParentID != nil {
+			comment["parentId"] = *body.ParentID
+		}
+		location := "/posts/" + postID + "/comments/" + comment["id"].(string)
+		if prefersMinimal(c) {
+			return createdMinimal(c, location)
+		}
+		c.Set(fiber.HeaderLocation, location)
+		c.Status(http.StatusCreated)
+		return sendResource(c, "comments", comment)
+	})
+
+	app.Get("/posts/:post_id/comments", routeName("list_comments"), func(c *fiber.Ctx) error {
+		postID := c.Params("post_id")
+		listSQL, shape := SQL_LIST_COMM_PAGE, shapeCommentRow
+		switch c.Query("expand") {
+		case "":
+		case "author":
+			claims, err := optionalClaims(c)
+			if err != nil {
+				return err
+			}
+			viewer := newUserViewer(claims)
+			if err := viewer.loadAdmins(c.UserContext(), pool); err != nil {
+				return err
+			}
+			listSQL, shape = SQL_LIST_COMM_AUTHOR, commentAuthorShaper(viewer)
+		default:
+			return fiber.NewError(http.StatusBadRequest, "expand must be author")
+		}
+		ctx, cancel := queryCtx(c)
+		// Ensure post exists
+		var one int
+		if err := pool.QueryRow(ctx, "SELECT 1 FROM posts WHERE id = $1 AND status = 'published'", postID).Scan(&one); err != nil {
+			cancel()
+			return fiber.NewError(http.StatusNotFound, "Post not found")
+		}
+		// Comments stay unpaginated unless a limit is requested or configured
+		var limit *int
+		limitVal, offset, err := parsePagination(c, "comments")
+		if err != nil {
+			cancel()
+			return err
+		}
+		if _, ok := DEFAULT_LIMITS["comments"]; ok || c.Query("limit") != "" {
+			limit = &limitVal
+		}
+		pageLimit := -1
+		if limit != nil {
+			pageLimit = *limit
+		}
+		if err := setRangeHeaders(c, pool, offset, pageLimit, SQL_COUNT_COMMENTS_BY_POST, postID); err != nil {
+			cancel()
+			return err
+		}
+		rows, err := timedQuery(ctx, pool, listSQL, postID, limit, offset)
+		if err != nil {
+			cancel()
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		if STREAM_LISTS {
+			return streamJSONArray(c, "comments", rowsWithCancel{rows, cancel}, shape)
+		}
+		defer cancel()
+		defer rows.Close()
+		list := make([]map[string]any, 0)
+		for rows.Next() {
+			comment, err := shape(rows)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			list = append(list, comment)
+		}
+		return sendCollection(c, "comments", list)
+	})
+
+	app.Get("/posts/:post_id/comments/tree", func(c *fiber.Ctx) error {
+		postID := c.Params("post_id")
+		ctx := c.UserContext()
+		// Ensure post exists
+		var one int
+		if err := pool.QueryRow(ctx, "SELECT 1 FROM posts WHERE id = $1 AND status = 'published'", postID).Scan(&one); err != nil {
+			return fiber.NewError(http.StatusNotFound, "Post not found")
+		}
+		// One extra row tells us the tree was cut off
+		rows, err := pool.Query(ctx, SQL_COMMENT_TREE, postID, maxTreeComments+1)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		defer rows.Close()
+		nodes := make([]map[string]any, 0)
+		for rows.Next() {
+			comment, err := shapeReplyRow(rows)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			nodes = append(nodes, comment)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		truncated := len(nodes) > maxTreeComments
+		if truncated {
+			nodes = nodes[:maxTreeComments]
+		}
+
+		// Replies whose parent fell past the cap are dropped with it
+		children := make(map[string][]map[string]any, len(nodes))
+		roots := make([]map[string]any, 0)
+		for _, node := range nodes {
+			if parentID, ok := node["parentId"].(string); ok {
+				children[parentID] = append(children[parentID], node)
+			} else {
+				roots = append(roots, node)
+			}
+		}
+		for _, node := range nodes {
+			replies := children[node["id"].(string)]
+			if replies == nil {
+				replies = make([]map[string]any, 0)
+			}
+			node["replies"] = replies
+		}
+		return sendMeta(c, fiber.Map{"items": roots, "truncated": truncated})
+	})
+
+	app.Delete("/posts/:post_id/comments", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+
+		postID := c.Params("post_id")
+		ctx := c.UserContext()
+		var authorID any
+		if err := pool.QueryRow(ctx, SQL_GET_POST_AUTH, postID).Scan(&authorID); err != nil {
+			return fiber.NewError(http.StatusNotFound, "Post not found")
+		}
+		if uuidToString(authorID) != fmt.Sprint(claims["sub"]) {
+			if err := requireAdmin(claims); err != nil {
+				return err
+			}
+		}
+		cmd, err := pool.Exec(ctx, SQL_DELETE_COMMS, postID)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Failed to delete comments")
+		}
+		return sendMeta(c, fiber.Map{"deleted": cmd.RowsAffected()})
+	})
+
+	app.Put("/posts/:post_id/comments/:comment_id", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		postID := c.Params("post_id")
+		commentID := c.Params("comment_id")
+		var body CommentCreate
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		if strings.TrimSpace(body.Content) == "" {
+			return fiber.NewError(http.StatusBadRequest, "Content must not be blank")
+		}
+		ctx := c.UserContext()
+		var authorID any
+		if err := pool.QueryRow(ctx, SQL_GET_COMM_AUTH, commentID, postID).Scan(&authorID); err != nil {
+			return fiber.NewError(http.StatusNotFound, "Comment not found")
+		}
+		if uuidToString(authorID) != fmt.Sprint(claims["sub"]) {
+			if err := requireAdmin(claims); err != nil {
+				return err
+			}
+		}
+		row := pool.QueryRow(ctx, SQL_UPDATE_COMMENT, commentID, postID, body.Content)
+		comment, err := shapeEditedCommentRow(row)
+		if err != nil {
+			return fiber.NewError(http.StatusNotFound, "Comment not found")
+		}
+		return sendResource(c, "comments", comment)
+	})
+
+	app.Post("/comments/batch", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		if err := requireAdmin(claims); err != nil {
+			return err
+		}
+
+		var body []BatchCommentCreate
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		if len(body) > maxBatchSize {
+			return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("At most %d records allowed", maxBatchSize))
+		}
+		bestEffort := c.Query("mode") == "besteffort"
+		results, err := runBatchInsert(c.UserContext(), pool, len(body), bestEffort, func(ctx context.Context, tx pgx.Tx, i int) (string, error) {
+			if strings.TrimSpace(body[i].Content) == "" {
+				return "", fiber.NewError(http.StatusBadRequest, "Content is required")
+			}
+			comment, err := shapeCommentRow(tx.QueryRow(ctx, SQL_CREATE_COMMENT, withCreatedAt(body[i].AuthorID, body[i].PostID, body[i].Content)...))
+			if err != nil {
+				if isMissingRefErr(err) || errors.Is(err, pgx.ErrNoRows) {
+					return "", fiber.NewError(http.StatusBadRequest, "Post or author not found")
+				}
+				return "", err
+			}
+			return comment["id"].(string), nil
+		})
+		if err != nil {
+			return err
+		}
+		if bestEffort {
+			c.Status(http.StatusMultiStatus)
+		} else {
+			c.Status(http.StatusCreated)
+		}
+		return sendMeta(c, results)
+	})
+
+	app.Get("/comments/recent", func(c *fiber.Ctx) error {
+		limit := c.QueryInt("limit", defaultPageLimit)
+		if limit < 1 {
+			return fiber.NewError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+		limit = min(limit, maxRecentComments)
+		ctx := c.UserContext()
+		rows, err := pool.Query(ctx, SQL_RECENT_COMMS, limit)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		defer rows.Close()
+		list := make([]map[string]any, 0)
+		for rows.Next() {
+			var idVal, authorVal, postVal any
+			var content, username string
+			var createdAt time.Time
+			if err := rows.Scan(&idVal, &authorVal, &postVal, &content, &createdAt, &username); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			authorID := uuidToString(authorVal)
+			list = append(list, map[string]any{
+				"id":        uuidToString(idVal),
+				"authorId":  authorID,
+				"postId":    uuidToString(postVal),
+				"content":   content,
+				"createdAt": formatTime(createdAt),
+				"author":    map[string]any{"id": authorID, "username": username},
+			})
+		}
+		return sendCollection(c, "comments", list)
+	})
+
+	app.Post("/posts/:post_id/like", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		postID := c.Params("post_id")
+		ctx := c.UserContext()
+		// Ensure post exists
+		var one int
+		if err := pool.QueryRow(ctx, "SELECT 1 FROM posts WHERE id = $1 AND status = 'published'", postID).Scan(&one); err != nil {
+			return fiber.NewError(http.StatusNotFound, "Post not found")
+		}
+		var exists int
+		if err := pool.QueryRow(ctx, SQL_LIKE_EXISTS, fmt.Sprint(claims["sub"]), postID).Scan(&exists); err == nil {
+			return fiber.NewError(http.StatusConflict, "Post already liked")
+		}
+		if MAX_LIKES_PER_USER > 0 {
+			var count int
+			if err := pool.QueryRow(ctx, SQL_COUNT_LIKES_BY, fmt.Sprint(claims["sub"])).Scan(&count); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Query error")
+			}
+			if count >= MAX_LIKES_PER_USER {
+				return fiber.NewError(http.StatusTooManyRequests, "Like limit reached")
+			}
+		}
+		if c.Query("return") == "representation" {
+			var userVal, postVal any
+			var createdAt time.Time
+			err := pool.QueryRow(ctx, SQL_CREATE_LIKE_R, fmt.Sprint(claims["sub"]), postID).Scan(&userVal, &postVal, &createdAt)
+			if errors.Is(err, pgx.ErrNoRows) {
+				// Lost a race with a concurrent like of the same post
+				return fiber.NewError(http.StatusConflict, "Post already liked")
+			}
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Failed to like")
+			}
+			c.Status(http.StatusCreated)
+			return sendMeta(c, fiber.Map{
+				"postId":    uuidToString(postVal),
+				"userId":    uuidToString(userVal),
+				"createdAt": formatTime(createdAt),
+			})
+		}
+		if _, err := pool.Exec(ctx, SQL_CREATE_LIKE, fmt.Sprint(claims["sub"]), postID); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Failed to like")
+		}
+		return sendNoContent(c)
+	})
+
+	app.Post("/posts/batch", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		if err := requireAdmin(claims); err != nil {
+			return err
+		}
+
+		var body []BatchPostCreate
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		if len(body) > maxBatchSize {
+			return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("At most %d records allowed", maxBatchSize))
+		}
+		bestEffort := c.Query("mode") == "besteffort"
+		results, err := runBatchInsert(c.UserContext(), pool, len(body), bestEffort, func(ctx context.Context, tx pgx.Tx, i int) (string, error) {
+			var id any
+			if err := tx.QueryRow(ctx, SQL_CREATE_POST_ID, withCreatedAt(body[i].AuthorID, body[i].Content)...).Scan(&id); err != nil {
+				return "", err
+			}
+			return uuidToString(id), nil
+		})
+		if err != nil {
+			return err
+		}
+		if bestEffort {
+			c.Status(http.StatusMultiStatus)
+		} else {
+			c.Status(http.StatusCreated)
+		}
+		return sendMeta(c, results)
+	})
+
+	app.Post("/posts/lookup", func(c *fiber.Ctx) error {
+		var body PostLookup
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		if len(body.IDs) > maxLookupIDs {
+			return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("At most %d ids allowed", maxLookupIDs))
+		}
+		list := make([]map[string]any, 0, len(body.IDs))
+		if len(body.IDs) == 0 {
+			return sendCollection(c, "posts", list)
+		}
+		ctx := c.UserContext()
+		shape := postShaper(c)
+		rows, err := pool.Query(ctx, SQL_LOOKUP_POSTS, body.IDs)
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid ids")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			post, err := shape(rows)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			list = append(list, post)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid ids")
+		}
+		return sendCollection(c, "posts", list)
+	})
+
+	app.Post("/posts/by-authors", func(c *fiber.Ctx) error {
+		var body PostsByAuthors
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		if len(body.AuthorIDs) > maxFeedAuthors {
+			return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("At most %d authors allowed", maxFeedAuthors))
+		}
+		list := make([]map[string]any, 0)
+		if len(body.AuthorIDs) == 0 {
+			return sendCollection(c, "posts", list)
+		}
+		limit, _, err := parsePagination(c, "posts_by_authors")
+		if err != nil {
+			return err
+		}
+		if body.Limit != nil {
+			limit = *body.Limit
+		}
+		limit, offset, err := checkPage(limit, body.Offset)
+		if err != nil {
+			return err
+		}
+		ctx := c.UserContext()
+		shape := postShaper(c)
+		rows, err := pool.Query(ctx, SQL_POSTS_BY_AUTHORS, body.AuthorIDs, limit, offset)
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid authorIds")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			post, err := shape(rows)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			list = append(list, post)
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid authorIds")
+		}
+		return sendCollection(c, "posts", list)
+	})
+
+	app.Post("/posts/liked-status", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+
+		var body LikedStatusRequest
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		}
+		if len(body.PostIDs) > maxLikedStatusIDs {
+			return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("At most %d postIds allowed", maxLikedStatusIDs))
+		}
+		status := make(map[string]bool, len(body.PostIDs))
+		for _, id := range body.PostIDs {
+			status[id] = false
+		}
+		if len(body.PostIDs) == 0 {
+			return sendMeta(c, status)
 		}
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		signed, err := token.SignedString([]byte(JWT_SECRET))
+		ctx := c.UserContext()
+		rows, err := pool.Query(ctx, SQL_LIKE_STATUS, body.PostIDs, fmt.Sprint(claims["sub"]))
 		if err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "Token error")
+			return fiber.NewError(http.StatusBadRequest, "Invalid postIds")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var postID string
+			if err := rows.Scan(&postID); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			status[postID] = true
 		}
-		return c.JSON(fiber.Map{"accessToken": signed})
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Invalid postIds")
+		}
+		return sendMeta(c, status)
 	})
 
-	app.Get("/auth/me", func(c *fiber.Ctx) error {
+	app.Delete("/posts/:post_id/like", func(c *fiber.Ctx) error {
 		tok, err := getTokenFromHeader(c)
 		if err != nil {
 			return err
@@ -350,17 +5437,21 @@ func main() {
 		if err != nil {
 			return err
 		}
-		ctx := c.Context()
-		id := fmt.Sprint(claims["sub"])
-		row := pool.QueryRow(ctx, SQL_ME, id)
-		user, err := shapeUserRow(row)
-		if err != nil {
-			return fiber.NewError(http.StatusUnauthorized, "Unauthorized")
+		postID := c.Params("post_id")
+		ctx := c.UserContext()
+		// Ensure post exists
+		var one int
+		if err := pool.QueryRow(ctx, "SELECT 1 FROM posts WHERE id = $1", postID).Scan(&one); err != nil {
+			return fiber.NewError(http.StatusNotFound, "Post not found")
+		}
+		cmd, err := pool.Exec(ctx, SQL_DELETE_LIKE, fmt.Sprint(claims["sub"]), postID)
+		if err != nil || cmd.RowsAffected() != 1 {
+			return fiber.NewError(http.StatusNotFound, "Post or like not found")
 		}
-		return c.JSON(user)
+		return sendNoContent(c)
 	})
 
-	app.Post("/users", func(c *fiber.Ctx) error {
+	app.Delete("/posts/:post_id/likes", func(c *fiber.Ctx) error {
 		tok, err := getTokenFromHeader(c)
 		if err != nil {
 			return err
@@ -369,32 +5460,97 @@ func main() {
 		if err != nil {
 			return err
 		}
-		if err := requireAdmin(claims); err != nil {
+
+		postID := c.Params("post_id")
+		ctx := c.UserContext()
+		var authorID any
+		if err := pool.QueryRow(ctx, SQL_GET_POST_AUTH, postID).Scan(&authorID); err != nil {
+			return fiber.NewError(http.StatusNotFound, "Post not found")
+		}
+		if err := requireSelfOrAdmin(claims, uuidToString(authorID)); err != nil {
 			return err
 		}
+		tx, err := pool.Begin(ctx)
+		if err != nil {
+			return fiber.NewError(http.StatusServiceUnavailable, "Database unavailable")
+		}
+		defer tx.Rollback(ctx)
+		cmd, err := tx.Exec(ctx, SQL_DELETE_POST_LIKES, postID)
+		if err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Failed to delete likes")
+		}
+		if _, err := tx.Exec(ctx, SQL_RESET_LIKE_COUNT, postID); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Failed to delete likes")
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Commit error")
+		}
+		return sendMeta(c, fiber.Map{"deleted": cmd.RowsAffected()})
+	})
 
-		var body CreateUser
-		if err := c.BodyParser(&body); err != nil {
-			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+	app.Get("/feed/digest", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
 		}
-		hash, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+		claims, err := decodeToken(tok)
 		if err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "Hash error")
+			return err
 		}
-		ctx := c.Context()
-		var newID any
-		if err := pool.QueryRow(ctx, SQL_CREATE_USER, body.Username, body.Email, string(hash), nil).Scan(&newID); err != nil {
-			return fiber.NewError(http.StatusBadRequest, "Failed to create user")
+
+		authors, perAuthor := c.QueryInt("authors", 10), c.QueryInt("posts", 3)
+		if authors < 1 || perAuthor < 1 {
+			return fiber.NewError(http.StatusBadRequest, "authors and posts must be positive integers")
 		}
-		row := pool.QueryRow(ctx, SQL_GET_USER, newID)
-		user, err := shapeUserRow(row)
+		authors, perAuthor = min(authors, maxDigestAuthors), min(perAuthor, maxDigestPosts)
+		ctx := c.UserContext()
+		viewer := newUserViewer(claims)
+		if err := viewer.loadAdmins(ctx, pool); err != nil {
+			return err
+		}
+		rows, err := pool.Query(ctx, SQL_FEED_DIGEST, fmt.Sprint(claims["sub"]), authors, perAuthor)
 		if err != nil {
-			return fiber.NewError(http.StatusNotFound, "User not found")
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		defer rows.Close()
+		// Rows arrive grouped by author, so a new group starts whenever the author changes
+		digest := make([]fiber.Map, 0)
+		var posts []map[string]any
+		lastAuthor := ""
+		for rows.Next() {
+			var userID, postID, authorID any
+			var username, email, content string
+			var bio *string
+			var userCreatedAt, postCreatedAt time.Time
+			var likeCount int32
+			if err := rows.Scan(&userID, &username, &email, &bio, &userCreatedAt,
+				&postID, &authorID, &content, &postCreatedAt, &likeCount); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			author := uuidToString(authorID)
+			if author != lastAuthor {
+				posts = make([]map[string]any, 0, perAuthor)
+				digest = append(digest, fiber.Map{
+					"author": viewer.apply(userMap(userID, username, email, bio, userCreatedAt)),
+				})
+				lastAuthor = author
+			}
+			posts = append(posts, map[string]any{
+				"id":        uuidToString(postID),
+				"authorId":  author,
+				"content":   content,
+				"likeCount": int(likeCount),
+				"createdAt": formatTime(postCreatedAt),
+			})
+			digest[len(digest)-1]["posts"] = posts
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
 		}
-		return c.Status(http.StatusCreated).JSON(user)
+		return sendMeta(c, digest)
 	})
 
-	app.Get("/users", func(c *fiber.Ctx) error {
+	app.Get("/feed/unseen", func(c *fiber.Ctx) error {
 		tok, err := getTokenFromHeader(c)
 		if err != nil {
 			return err
@@ -403,30 +5559,33 @@ func main() {
 		if err != nil {
 			return err
 		}
-		if err := requireAdmin(claims); err != nil {
+
+		limit, offset, err := parsePagination(c, "feed")
+		if err != nil {
 			return err
 		}
-
-		limit, _ := strconv.Atoi(c.Query("limit", "20"))
-		offset, _ := strconv.Atoi(c.Query("offset", "0"))
-		ctx := c.Context()
-		rows, err := pool.Query(ctx, SQL_LIST_USERS, limit, offset)
+		ctx := c.UserContext()
+		shape := postShaper(c)
+		rows, err := pool.Query(ctx, SQL_FEED_UNSEEN, fmt.Sprint(claims["sub"]), limit, offset)
 		if err != nil {
 			return fiber.NewError(http.StatusInternalServerError, "Query error")
 		}
+		if STREAM_LISTS {
+			return streamJSONArray(c, "posts", rows, shape)
+		}
 		defer rows.Close()
 		list := make([]map[string]any, 0)
 		for rows.Next() {
-			user, err := shapeUserRow(rows)
+			post, err := shape(rows)
 			if err != nil {
 				return fiber.NewError(http.StatusInternalServerError, "Scan error")
 			}
-			list = append(list, user)
+			list = append(list, post)
 		}
-		return c.JSON(list)
+		return sendCollection(c, "posts", list)
 	})
 
-	app.Put("/users/:user_id", func(c *fiber.Ctx) error {
+	app.Get("/feed/new", func(c *fiber.Ctx) error {
 		tok, err := getTokenFromHeader(c)
 		if err != nil {
 			return err
@@ -435,25 +5594,33 @@ func main() {
 		if err != nil {
 			return err
 		}
-		if err := requireAdmin(claims); err != nil {
-			return err
-		}
 
-		userID := c.Params("user_id")
-		var body UpdateUser
-		if err := c.BodyParser(&body); err != nil {
-			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		limit, offset, err := parsePagination(c, "feed")
+		if err != nil {
+			return err
 		}
-		ctx := c.Context()
-		row := pool.QueryRow(ctx, SQL_UPDATE_USER, userID, body.Bio)
-		user, err := shapeUserRow(row)
+		ctx := c.UserContext()
+		shape := postShaper(c)
+		rows, err := pool.Query(ctx, SQL_FEED_NEW, fmt.Sprint(claims["sub"]), limit, offset)
 		if err != nil {
-			return fiber.NewError(http.StatusNotFound, "User not found")
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		if STREAM_LISTS {
+			return streamJSONArray(c, "posts", rows, shape)
+		}
+		defer rows.Close()
+		list := make([]map[string]any, 0)
+		for rows.Next() {
+			post, err := shape(rows)
+			if err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			list = append(list, post)
 		}
-		return c.JSON(user)
+		return sendCollection(c, "posts", list)
 	})
 
-	app.Delete("/users/:user_id", func(c *fiber.Ctx) error {
+	app.Post("/feed/seen", func(c *fiber.Ctx) error {
 		tok, err := getTokenFromHeader(c)
 		if err != nil {
 			return err
@@ -462,20 +5629,16 @@ func main() {
 		if err != nil {
 			return err
 		}
-		if err := requireAdmin(claims); err != nil {
-			return err
-		}
 
-		userID := c.Params("user_id")
-		ctx := c.Context()
-		cmd, err := pool.Exec(ctx, SQL_DELETE_USER, userID)
-		if err != nil || cmd.RowsAffected() != 1 {
-			return fiber.NewError(http.StatusNotFound, "User not found")
+		ctx := c.UserContext()
+		var seenAt time.Time
+		if err := pool.QueryRow(ctx, SQL_FEED_SEEN, fmt.Sprint(claims["sub"])).Scan(&seenAt); err != nil {
+			return fiber.NewError(http.StatusUnauthorized, "Unauthorized")
 		}
-		return c.SendStatus(http.StatusNoContent)
+		return sendMeta(c, fiber.Map{"lastFeedSeenAt": formatTime(seenAt)})
 	})
 
-	app.Post("/posts", func(c *fiber.Ctx) error {
+	app.Post("/follows/batch", func(c *fiber.Ctx) error {
 		tok, err := getTokenFromHeader(c)
 		if err != nil {
 			return err
@@ -484,61 +5647,99 @@ func main() {
 		if err != nil {
 			return err
 		}
+		if err := requireAdmin(claims); err != nil {
+			return err
+		}
 
-		var body PostCreate
+		var body []FollowPair
 		if err := c.BodyParser(&body); err != nil {
 			return fiber.NewError(http.StatusBadRequest, "Invalid body")
 		}
-		userID := fmt.Sprint(claims["sub"])
-		ctx := c.Context()
-		row := pool.QueryRow(ctx, SQL_CREATE_POST, userID, body.Content)
-		var idVal, authorVal any
-		var content string
-		var createdAt time.Time
-		if err := row.Scan(&idVal, &authorVal, &content, &createdAt); err != nil {
-			return fiber.NewError(http.StatusBadRequest, "Failed to create post")
+		if len(body) > maxBatchSize {
+			return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("At most %d records allowed", maxBatchSize))
 		}
-		return c.Status(http.StatusCreated).JSON(fiber.Map{
-			"id":        uuidToString(idVal),
-			"authorId":  uuidToString(authorVal),
-			"content":   content,
-			"createdAt": createdAt,
-			"likeCount": 0,
-		})
+		batch := &pgx.Batch{}
+		for _, pair := range body {
+			// Self-follows are rejected by the table constraint, skip them up front
+			if pair.Follower == pair.Followee {
+				continue
+			}
+			batch.Queue(SQL_CREATE_FOLLOW, pair.Follower, pair.Followee)
+		}
+		if batch.Len() == 0 {
+			c.Status(http.StatusCreated)
+			return sendMeta(c, fiber.Map{"created": 0})
+		}
+		ctx := c.UserContext()
+		conn, err := acquireConn(ctx, pool)
+		if err != nil {
+			return err
+		}
+		defer conn.Release()
+		results := conn.SendBatch(ctx, batch)
+		created := int64(0)
+		for i := 0; i < batch.Len(); i++ {
+			cmd, err := results.Exec()
+			if err != nil {
+				results.Close()
+				return fiber.NewError(http.StatusBadRequest, "Failed to create follows")
+			}
+			created += cmd.RowsAffected()
+		}
+		if err := results.Close(); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "Failed to create follows")
+		}
+		c.Status(http.StatusCreated)
+		return sendMeta(c, fiber.Map{"created": created})
 	})
 
-	app.Get("/posts", func(c *fiber.Ctx) error {
-		limit, _ := strconv.Atoi(c.Query("limit", "20"))
-		offset, _ := strconv.Atoi(c.Query("offset", "0"))
-		ctx := c.Context()
-		rows, err := pool.Query(ctx, SQL_LIST_POSTS, limit, offset)
+	app.Get("/hashtags/trending", func(c *fiber.Ctx) error {
+		window, err := time.ParseDuration(c.Query("window", "24h"))
+		if err != nil || window <= 0 || window > maxTrendingWindow {
+			return fiber.NewError(http.StatusBadRequest, "Invalid window")
+		}
+		limit := c.QueryInt("limit", 10)
+		if limit < 1 {
+			return fiber.NewError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+		limit = min(limit, maxTrendingTags)
+		ctx := c.UserContext()
+		rows, err := pool.Query(ctx, SQL_TRENDING_TAGS, window.Seconds(), limit)
 		if err != nil {
 			return fiber.NewError(http.StatusInternalServerError, "Query error")
 		}
 		defer rows.Close()
-		list := make([]map[string]any, 0)
+		tags := make([]fiber.Map, 0, limit)
 		for rows.Next() {
-			post, err := shapePostRow(rows)
-			if err != nil {
+			var tag string
+			var posts int64
+			if err := rows.Scan(&tag, &posts); err != nil {
 				return fiber.NewError(http.StatusInternalServerError, "Scan error")
 			}
-			list = append(list, post)
+			tags = append(tags, fiber.Map{"tag": tag, "posts": posts})
 		}
-		return c.JSON(list)
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		return sendMeta(c, tags)
 	})
 
-	app.Get("/posts/:post_id", func(c *fiber.Ctx) error {
-		postID := c.Params("post_id")
-		ctx := c.Context()
-		row := pool.QueryRow(ctx, SQL_GET_POST, postID)
-		post, err := shapePostRow(row)
+	app.Get("/admin/config", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
 		if err != nil {
-			return fiber.NewError(http.StatusNotFound, "Post not found")
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		if err := requireAdmin(claims); err != nil {
+			return err
 		}
-		return c.JSON(post)
+		return sendMeta(c, publicConfig(pool))
 	})
 
-	app.Delete("/posts/:post_id", func(c *fiber.Ctx) error {
+	app.Get("/admin/posts/all", routeName("export"), func(c *fiber.Ctx) error {
 		tok, err := getTokenFromHeader(c)
 		if err != nil {
 			return err
@@ -547,25 +5748,22 @@ func main() {
 		if err != nil {
 			return err
 		}
-
-		postID := c.Params("post_id")
-		ctx := c.Context()
-		var authorID any
-		if err := pool.QueryRow(ctx, SQL_GET_POST_AUTH, postID).Scan(&authorID); err != nil {
-			return fiber.NewError(http.StatusNotFound, "Post not found")
-		}
-		if uuidToString(authorID) != fmt.Sprint(claims["sub"]) {
-			if err := requireAdmin(claims); err != nil {
-				return err
-			}
+		if err := requireAdmin(claims); err != nil {
+			return err
 		}
-		if _, err := pool.Exec(ctx, SQL_DELETE_POST, postID); err != nil {
-			return fiber.NewError(http.StatusNotFound, "Post not found")
+
+		ctx, cancel := queryCtx(c)
+		rows, err := timedQuery(ctx, pool, SQL_EXPORT_POSTS)
+		if err != nil {
+			cancel()
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
 		}
-		return c.SendStatus(http.StatusNoContent)
+		return streamNDJSON(c, "posts", rowsWithCancel{rows, cancel}, shapeExportedPostRow)
 	})
 
-	app.Post("/posts/:post_id/comments", func(c *fiber.Ctx) error {
+	explainable := explainTargets()
+
+	app.Get("/admin/stats/like-distribution", func(c *fiber.Ctx) error {
 		tok, err := getTokenFromHeader(c)
 		if err != nil {
 			return err
@@ -574,50 +5772,84 @@ func main() {
 		if err != nil {
 			return err
 		}
-		postID := c.Params("post_id")
-		ctx := c.Context()
-		// Ensure post exists
-		var one int
-		if err := pool.QueryRow(ctx, "SELECT 1 FROM posts WHERE id = $1", postID).Scan(&one); err != nil {
-			return fiber.NewError(http.StatusNotFound, "Post not found")
-		}
-		var body CommentCreate
-		if err := c.BodyParser(&body); err != nil {
-			return fiber.NewError(http.StatusBadRequest, "Invalid body")
+		if err := requireAdmin(claims); err != nil {
+			return err
 		}
-		row := pool.QueryRow(ctx, SQL_CREATE_COMMENT, fmt.Sprint(claims["sub"]), postID, body.Content)
-		comment, err := shapeCommentRow(row)
+
+		ctx := c.UserContext()
+		rows, err := pool.Query(ctx, SQL_LIKE_HISTOGRAM)
 		if err != nil {
-			return fiber.NewError(http.StatusBadRequest, "Failed to create comment")
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		defer rows.Close()
+		counts := make(map[string]int64, len(likeBuckets))
+		for rows.Next() {
+			var bucket string
+			var posts int64
+			if err := rows.Scan(&bucket, &posts); err != nil {
+				return fiber.NewError(http.StatusInternalServerError, "Scan error")
+			}
+			counts[bucket] = posts
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
 		}
-		return c.Status(http.StatusCreated).JSON(comment)
+		histogram := make([]fiber.Map, 0, len(likeBuckets))
+		for _, bucket := range likeBuckets {
+			histogram = append(histogram, fiber.Map{"bucket": bucket, "posts": counts[bucket]})
+		}
+		return sendMeta(c, histogram)
 	})
 
-	app.Get("/posts/:post_id/comments", func(c *fiber.Ctx) error {
-		postID := c.Params("post_id")
-		ctx := c.Context()
-		// Ensure post exists
-		var one int
-		if err := pool.QueryRow(ctx, "SELECT 1 FROM posts WHERE id = $1", postID).Scan(&one); err != nil {
-			return fiber.NewError(http.StatusNotFound, "Post not found")
+	app.Get("/admin/stats/engagement", func(c *fiber.Ctx) error {
+		tok, err := getTokenFromHeader(c)
+		if err != nil {
+			return err
+		}
+		claims, err := decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		if err := requireAdmin(claims); err != nil {
+			return err
 		}
-		rows, err := pool.Query(ctx, SQL_LIST_COMMENTS, postID)
+
+		interval := c.Query("interval", "day")
+		maxDays, ok := engagementIntervals[interval]
+		if !ok {
+			return fiber.NewError(http.StatusBadRequest, "interval must be hour, day or week")
+		}
+		days := c.QueryInt("days", 30)
+		if days < 1 || days > maxDays {
+			return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("days must be between 1 and %d for %s buckets", maxDays, interval))
+		}
+		ctx := c.UserContext()
+		rows, err := pool.Query(ctx, SQL_ENGAGEMENT_SERIES, interval, days)
 		if err != nil {
 			return fiber.NewError(http.StatusInternalServerError, "Query error")
 		}
 		defer rows.Close()
-		list := make([]map[string]any, 0)
+		series := make([]fiber.Map, 0)
 		for rows.Next() {
-			comment, err := shapeCommentRow(rows)
-			if err != nil {
+			var bucket time.Time
+			var posts, comments, likes int64
+			if err := rows.Scan(&bucket, &posts, &comments, &likes); err != nil {
 				return fiber.NewError(http.StatusInternalServerError, "Scan error")
 			}
-			list = append(list, comment)
+			series = append(series, fiber.Map{
+				"bucket":   formatTime(bucket),
+				"posts":    posts,
+				"comments": comments,
+				"likes":    likes,
+			})
+		}
+		if err := rows.Err(); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
 		}
-		return c.JSON(list)
+		return sendMeta(c, fiber.Map{"interval": interval, "days": days, "series": series})
 	})
 
-	app.Post("/posts/:post_id/like", func(c *fiber.Ctx) error {
+	app.Get("/admin/dataset-info", func(c *fiber.Ctx) error {
 		tok, err := getTokenFromHeader(c)
 		if err != nil {
 			return err
@@ -626,24 +5858,48 @@ func main() {
 		if err != nil {
 			return err
 		}
-		postID := c.Params("post_id")
-		ctx := c.Context()
-		// Ensure post exists
-		var one int
-		if err := pool.QueryRow(ctx, "SELECT 1 FROM posts WHERE id = $1", postID).Scan(&one); err != nil {
-			return fiber.NewError(http.StatusNotFound, "Post not found")
+		if err := requireAdmin(claims); err != nil {
+			return err
 		}
-		var exists int
-		if err := pool.QueryRow(ctx, SQL_LIKE_EXISTS, fmt.Sprint(claims["sub"]), postID).Scan(&exists); err == nil {
-			return fiber.NewError(http.StatusConflict, "Post already liked")
+
+		ctx := c.UserContext()
+		batch := &pgx.Batch{}
+		batch.Queue(SQL_ROW_COUNTS)
+		// Last, since a database without schema_migrations fails the rest of the batch
+		batch.Queue(SQL_SCHEMA_VERSION)
+		conn, err := acquireConn(ctx, pool)
+		if err != nil {
+			return err
 		}
-		if _, err := pool.Exec(ctx, SQL_CREATE_LIKE, fmt.Sprint(claims["sub"]), postID); err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "Failed to like")
+		defer conn.Release()
+		results := conn.SendBatch(ctx, batch)
+		defer results.Close()
+
+		var users, posts, comments, likes, follows int64
+		if err := results.QueryRow().Scan(&users, &posts, &comments, &likes, &follows); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Query error")
+		}
+		var version *int
+		if err := results.QueryRow().Scan(&version); err != nil {
+			var pgErr *pgconn.PgError
+			// undefined_table: migrations up to 021 have not been applied
+			if !errors.As(err, &pgErr) || pgErr.Code != "42P01" {
+				return fiber.NewError(http.StatusInternalServerError, "Query error")
+			}
 		}
-		return c.SendStatus(http.StatusNoContent)
+		return sendMeta(c, fiber.Map{
+			"schemaVersion": version,
+			"rowCounts": fiber.Map{
+				"users":    users,
+				"posts":    posts,
+				"comments": comments,
+				"likes":    likes,
+				"follows":  follows,
+			},
+		})
 	})
 
-	app.Delete("/posts/:post_id/like", func(c *fiber.Ctx) error {
+	app.Get("/admin/explain", func(c *fiber.Ctx) error {
 		tok, err := getTokenFromHeader(c)
 		if err != nil {
 			return err
@@ -652,18 +5908,29 @@ func main() {
 		if err != nil {
 			return err
 		}
-		postID := c.Params("post_id")
-		ctx := c.Context()
-		// Ensure post exists
-		var one int
-		if err := pool.QueryRow(ctx, "SELECT 1 FROM posts WHERE id = $1", postID).Scan(&one); err != nil {
-			return fiber.NewError(http.StatusNotFound, "Post not found")
+		if err := requireAdmin(claims); err != nil {
+			return err
 		}
-		cmd, err := pool.Exec(ctx, SQL_DELETE_LIKE, fmt.Sprint(claims["sub"]), postID)
-		if err != nil || cmd.RowsAffected() != 1 {
-			return fiber.NewError(http.StatusNotFound, "Post or like not found")
+
+		target, ok := explainable[c.Query("query")]
+		if !ok {
+			return fiber.NewError(http.StatusBadRequest, "Unknown query")
+		}
+		ctx := c.UserContext()
+		args := target.args
+		if target.sample != "" {
+			var id string
+			if err := pool.QueryRow(ctx, target.sample).Scan(&id); err != nil {
+				return fiber.NewError(http.StatusNotFound, "No sample data")
+			}
+			args = append([]any{id}, args...)
 		}
-		return c.SendStatus(http.StatusNoContent)
+		var plan []byte
+		if err := pool.QueryRow(ctx, "EXPLAIN (ANALYZE, FORMAT JSON) "+target.sql, args...).Scan(&plan); err != nil {
+			return fiber.NewError(http.StatusInternalServerError, "Explain error")
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Send(plan)
 	})
 
 	port := os.Getenv("PORT")
@@ -693,6 +5960,14 @@ func main() {
 	if err := app.ShutdownWithContext(ctx); err != nil {
 		log.Printf("Shutdown error: %v", err)
 	}
+	if commentQueue != nil {
+		commentQueue.flush(ctx)
+	}
+	if shutdownTracing != nil {
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("Tracing shutdown error: %v", err)
+		}
+	}
 
 	log.Println("Server stopped")
 }
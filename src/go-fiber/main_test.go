@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSlowHeadersCloseConnection(t *testing.T) {
+	prev := READ_HEADER_TIMEOUT_MS
+	READ_HEADER_TIMEOUT_MS = 200
+	defer func() { READ_HEADER_TIMEOUT_MS = prev }()
+
+	app := newApp()
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// A header byte every 50ms never completes the request within the 200ms budget
+	for _, b := range []byte("GET / HTTP/1.1\r\nHost: localhost\r\n") {
+		if _, err := conn.Write([]byte{b}); err != nil {
+			return // the server already hung up
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	// The server may answer 408 first; what matters is that it closes the connection
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = io.ReadAll(conn)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		t.Fatal("connection still open after the header timeout")
+	}
+}
+
+func TestIsJSONMediaType(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"application/vnd.api+json", true},
+		{"APPLICATION/JSON", true},
+		{"text/plain+json", true},
+		{"application/jsonx", false},
+		{"application/+json", false},
+		{"text/plain", false},
+		{"application/json; charset", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isJSONMediaType(tt.header); got != tt.want {
+			t.Errorf("isJSONMediaType(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestUserViewerApply(t *testing.T) {
+	const self, other = "11111111-1111-1111-1111-111111111111", "22222222-2222-2222-2222-222222222222"
+	admin := newUserViewer(jwt.MapClaims{"sub": "33333333-3333-3333-3333-333333333333", "is_admin": true})
+	admin.admins = map[string]bool{other: true}
+	tests := []struct {
+		name      string
+		viewer    *userViewer
+		user      string
+		wantEmail bool
+		wantAdmin any // nil when isAdmin must be absent
+	}{
+		{"anonymous", newUserViewer(nil), other, false, nil},
+		{"other user", newUserViewer(jwt.MapClaims{"sub": self}), other, false, nil},
+		{"self", newUserViewer(jwt.MapClaims{"sub": self}), self, true, false},
+		{"admin", admin, other, true, true},
+		{"admin on non-admin", admin, self, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := tt.viewer.apply(userMap(tt.user, "alice", "alice@example.com", nil, time.Now()))
+			if _, ok := user["email"]; ok != tt.wantEmail {
+				t.Errorf("email present = %v, want %v", ok, tt.wantEmail)
+			}
+			isAdmin, ok := user["isAdmin"]
+			if tt.wantAdmin == nil {
+				if ok {
+					t.Errorf("isAdmin = %v, want absent", isAdmin)
+				}
+			} else if isAdmin != tt.wantAdmin {
+				t.Errorf("isAdmin = %v, want %v", isAdmin, tt.wantAdmin)
+			}
+		})
+	}
+}
+
+func TestWeakHashResetFlow(t *testing.T) {
+	prev := MIN_BCRYPT_COST
+	MIN_BCRYPT_COST = 11
+	defer func() { MIN_BCRYPT_COST = prev }()
+
+	weak, err := bcrypt.GenerateFromPassword([]byte("old-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus := func(err error, code int, msg string) {
+		t.Helper()
+		var fe *fiber.Error
+		if !errors.As(err, &fe) || fe.Code != code || fe.Message != msg {
+			t.Fatalf("err = %v, want %d %q", err, code, msg)
+		}
+	}
+
+	// A wrong password never reveals or flags the weak hash
+	flag, err := checkLogin(string(weak), "wrong-password", false)
+	wantStatus(err, http.StatusUnauthorized, "Invalid credentials")
+	if flag {
+		t.Fatal("wrong password flagged the account")
+	}
+
+	// The right password on a weak hash is refused and flags the account
+	flag, err = checkLogin(string(weak), "old-password", false)
+	wantStatus(err, http.StatusUnauthorized, "Password reset required")
+	if !flag {
+		t.Fatal("weak hash not flagged")
+	}
+	// Once flagged it stays refused, without flagging again
+	flag, err = checkLogin(string(weak), "old-password", true)
+	wantStatus(err, http.StatusUnauthorized, "Password reset required")
+	if flag {
+		t.Fatal("already flagged account flagged again")
+	}
+
+	// The reset writes a hash at the floor and clears must_reset_password
+	reset, err := hashPassword("new-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, _ := bcrypt.Cost(reset); cost < MIN_BCRYPT_COST {
+		t.Fatalf("reset hash cost = %d, want at least %d", cost, MIN_BCRYPT_COST)
+	}
+	if _, err := checkLogin(string(reset), "new-password", false); err != nil {
+		t.Fatalf("login after reset: %v", err)
+	}
+}